@@ -0,0 +1,501 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const ANNOTATION_PREFIX = "qpoint.io/"
+const DEFAULT_STATUS_PORT int32 = 10001
+
+type AnnotationType string
+
+const (
+	AnnotationType_STRING         AnnotationType = "string"
+	AnnotationType_BOOL           AnnotationType = "bool"
+	AnnotationType_ID             AnnotationType = "id"
+	AnnotationType_ID_LIST        AnnotationType = "id-list"
+	AnnotationType_ENUM           AnnotationType = "enum"
+	AnnotationType_ENUM_LIST      AnnotationType = "enum-list"
+	AnnotationType_NAME_LIST      AnnotationType = "name-list"
+	AnnotationType_SECRET_NAME    AnnotationType = "secret-name"
+	AnnotationType_IMAGE_TAG      AnnotationType = "image-tag"
+	AnnotationType_LISTEN_ADDRESS AnnotationType = "listen-address"
+	AnnotationType_PORT_MAPPING   AnnotationType = "port-mapping"
+	AnnotationType_REGEX_LIST     AnnotationType = "regex-list"
+	AnnotationType_URL            AnnotationType = "url"
+	AnnotationType_CA_SOURCE_LIST AnnotationType = "ca-source-list"
+	AnnotationType_QUANTITY       AnnotationType = "quantity"
+	AnnotationType_IMAGE_REPO     AnnotationType = "image-repository"
+	AnnotationType_IMAGE_DIGEST   AnnotationType = "image-digest"
+	AnnotationType_CAPABILITIES   AnnotationType = "capabilities"
+)
+
+// the largest UID/GID accepted by the kernel for a user namespace
+const maxId = 2147483647
+
+var imageTagRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+var capabilityRegexp = regexp.MustCompile(`^[A-Z][A-Z_]*$`)
+
+// Annotation describes a single qpoint.io annotation. The value is validated according to the type and,
+// depending on the annotation, is stored in the typed settings and/or passed as an environment variable to
+// the injected container.
+type Annotation struct {
+	Key       string
+	Type      AnnotationType
+	Default   string
+	Allowed   []string
+	Container string
+	Env       string
+	apply     func(s *Settings, v any)
+}
+
+// Name returns the fully qualified annotation name
+func (a *Annotation) Name() string {
+	return ANNOTATION_PREFIX + a.Key
+}
+
+// Parse validates the value and converts it into the type of the annotation
+func (a *Annotation) Parse(value string) (any, error) {
+	switch a.Type {
+	case AnnotationType_BOOL:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	case AnnotationType_ID:
+		return parseId(value)
+	case AnnotationType_ID_LIST:
+		ids := []int64{}
+		for _, v := range strings.Split(value, ",") {
+			id, err := parseId(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("must be a comma separated list of ids: %w", err)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	case AnnotationType_ENUM:
+		for _, allowed := range a.Allowed {
+			if value == allowed {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("must be one of %s", strings.Join(a.Allowed, ", "))
+	case AnnotationType_ENUM_LIST:
+		values := []string{}
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			valid := false
+			for _, allowed := range a.Allowed {
+				valid = valid || v == allowed
+			}
+			if !valid {
+				return nil, fmt.Errorf("'%s' must be one of %s", v, strings.Join(a.Allowed, ", "))
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case AnnotationType_NAME_LIST:
+		names := []string{}
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if errs := validation.IsDNS1123Label(v); len(errs) > 0 {
+				return nil, fmt.Errorf("'%s' is not a valid container name: %s", v, strings.Join(errs, ", "))
+			}
+			names = append(names, v)
+		}
+		return names, nil
+	case AnnotationType_SECRET_NAME:
+		// the secret is synced with a prefix which needs to result in a valid name as well
+		if errs := validation.IsDNS1123Subdomain(SyncedTokenSecret(value)); len(errs) > 0 {
+			return nil, fmt.Errorf("'%s' is not a valid secret name: %s", value, strings.Join(errs, ", "))
+		}
+		return value, nil
+	case AnnotationType_IMAGE_TAG:
+		if !imageTagRegexp.MatchString(value) {
+			return nil, fmt.Errorf("must be a valid image tag")
+		}
+		return value, nil
+	case AnnotationType_IMAGE_REPO:
+		if !imageRepositoryRegexp.MatchString(value) {
+			return nil, fmt.Errorf("must be an image repository without a tag or digest")
+		}
+		return value, nil
+	case AnnotationType_IMAGE_DIGEST:
+		if !imageDigestRegexp.MatchString(value) {
+			return nil, fmt.Errorf("must be a sha256:<hex> image digest")
+		}
+		return value, nil
+	case AnnotationType_CAPABILITIES:
+		capabilities := []corev1.Capability{}
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimPrefix(strings.TrimSpace(v), "CAP_")
+			if !capabilityRegexp.MatchString(v) {
+				return nil, fmt.Errorf("'%s' is not a capability such as NET_ADMIN", v)
+			}
+			capabilities = append(capabilities, corev1.Capability(v))
+		}
+		return capabilities, nil
+	case AnnotationType_LISTEN_ADDRESS:
+		return parseListenAddress(value)
+	case AnnotationType_PORT_MAPPING:
+		for _, mapping := range strings.Split(value, ",") {
+			from, to, found := strings.Cut(strings.TrimSpace(mapping), ":")
+			if !found {
+				return nil, fmt.Errorf("'%s' must be in the format <listen port>:<destination port>", mapping)
+			}
+			if _, err := parsePort(from); err != nil {
+				return nil, fmt.Errorf("'%s' has an invalid listen port: %w", mapping, err)
+			}
+			if _, err := parsePort(to); err != nil {
+				return nil, fmt.Errorf("'%s' has an invalid destination port: %w", mapping, err)
+			}
+		}
+		return value, nil
+	case AnnotationType_REGEX_LIST:
+		regexps := []*regexp.Regexp{}
+		for _, filter := range strings.Split(value, ",") {
+			r, err := regexp.Compile(filter)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression '%s': %w", filter, err)
+			}
+			regexps = append(regexps, r)
+		}
+		return regexps, nil
+	case AnnotationType_URL:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("must be an http or https url")
+		}
+		return value, nil
+	case AnnotationType_CA_SOURCE_LIST:
+		return ParseExtraCaSources(value, "")
+	case AnnotationType_QUANTITY:
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be a resource quantity such as 100m or 64Mi")
+		}
+		if q.Sign() < 0 {
+			return nil, fmt.Errorf("must not be negative")
+		}
+		return q, nil
+	default:
+		return value, nil
+	}
+}
+
+// ListenAddress is a parsed host:port listen address
+type ListenAddress struct {
+	Host string
+	Port int32
+}
+
+func parseId(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not an integer", value)
+	}
+	if id < 0 || id > maxId {
+		return 0, fmt.Errorf("'%s' must be between 0 and %d", value, maxId)
+	}
+	return id, nil
+}
+
+func parsePort(value string) (int32, error) {
+	port, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("'%s' must be a port between 1 and 65535", value)
+	}
+	return int32(port), nil
+}
+
+func parseListenAddress(value string) (ListenAddress, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return ListenAddress{}, fmt.Errorf("must be in the format <host>:<port>")
+	}
+	if host != "" && host != "localhost" && net.ParseIP(host) == nil {
+		return ListenAddress{}, fmt.Errorf("'%s' is not an IP address", host)
+	}
+	p, err := parsePort(port)
+	if err != nil {
+		return ListenAddress{}, err
+	}
+	return ListenAddress{Host: host, Port: p}, nil
+}
+
+// Annotations is the registry of every qpoint.io annotation understood by the operator. The order of the
+// registry is the order the environment variables are added to the containers.
+var Annotations = []Annotation{
+	{Key: "inject-ca", Type: AnnotationType_BOOL, Default: "false",
+		apply: func(s *Settings, v any) { s.InjectCa = v.(bool) }},
+	{Key: "inject-ca-mode", Type: AnnotationType_ENUM, Default: string(CaMode_REPLACE),
+		Allowed: []string{string(CaMode_REPLACE), string(CaMode_MERGE)},
+		apply:   func(s *Settings, v any) { s.CaMode = CaMode(v.(string)) }},
+	{Key: "inject-ca-java", Type: AnnotationType_BOOL, Default: "false",
+		apply: func(s *Settings, v any) { s.InjectCaJava = v.(bool) }},
+	{Key: "inject-ca-env", Type: AnnotationType_ENUM_LIST, Allowed: caEnvRuntimeNames(),
+		apply: func(s *Settings, v any) { s.CaEnv = v.([]string) }},
+	{Key: "inject-ca-conflict-policy", Type: AnnotationType_ENUM, Default: string(ConflictPolicy_SKIP),
+		Allowed: []string{string(ConflictPolicy_SKIP), string(ConflictPolicy_REPLACE), string(ConflictPolicy_FAIL)},
+		apply:   func(s *Settings, v any) { s.CaConflictPolicy = ConflictPolicy(v.(string)) }},
+	{Key: "inject-ca-containers", Type: AnnotationType_NAME_LIST,
+		apply: func(s *Settings, v any) { s.CaContainers = v.([]string) }},
+	{Key: "inject-ca-init-containers", Type: AnnotationType_NAME_LIST,
+		apply: func(s *Settings, v any) { s.CaInitContainers = v.([]string) }},
+	{Key: "exclude-containers", Type: AnnotationType_NAME_LIST,
+		apply: func(s *Settings, v any) { s.ExcludeContainers = v.([]string) }},
+	// read from the namespace by the namespace controller
+	{Key: "extra-ca-sources", Type: AnnotationType_CA_SOURCE_LIST},
+	{Key: "image-pull-policy", Type: AnnotationType_ENUM,
+		Allowed: []string{string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever)},
+		apply:   func(s *Settings, v any) { s.ImagePullPolicy = corev1.PullPolicy(v.(string)) }},
+	{Key: "proxy-env-target", Type: AnnotationType_ENUM, Default: string(ProxyEnvTarget_SIDECAR),
+		Allowed: []string{string(ProxyEnvTarget_SIDECAR), string(ProxyEnvTarget_SERVICE)},
+		apply:   func(s *Settings, v any) { s.ProxyEnvTarget = ProxyEnvTarget(v.(string)) }},
+	{Key: "proxy-env-no-proxy", Type: AnnotationType_STRING,
+		apply: func(s *Settings, v any) { s.ProxyEnvNoProxy = splitList(v.(string)) }},
+
+	// qtap-init
+	{Key: "qtap-init-tag", Type: AnnotationType_IMAGE_TAG, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.Tag = v.(string) }},
+	{Key: "qtap-init-repository", Type: AnnotationType_IMAGE_REPO, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.Repository = v.(string) }},
+	{Key: "qtap-init-digest", Type: AnnotationType_IMAGE_DIGEST, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.Digest = v.(string) }},
+	{Key: "qtap-init-run-as-user", Type: AnnotationType_ID, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.RunAsUser = ptr(v.(int64)) }},
+	{Key: "qtap-init-run-as-group", Type: AnnotationType_ID, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.RunAsGroup = ptr(v.(int64)) }},
+	{Key: "qtap-init-run-as-non-root", Type: AnnotationType_BOOL, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.RunAsNonRoot = ptr(v.(bool)) }},
+	{Key: "qtap-init-run-as-privileged", Type: AnnotationType_BOOL, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.Privileged = ptr(v.(bool)) }},
+	{Key: "qtap-init-capabilities", Type: AnnotationType_CAPABILITIES, Container: "qtap-init", Default: "NET_ADMIN,NET_RAW",
+		apply: func(s *Settings, v any) { s.Init.Capabilities = v.([]corev1.Capability) }},
+	{Key: "qtap-init-allow-privilege-escalation", Type: AnnotationType_BOOL, Container: "qtap-init", Default: "false",
+		apply: func(s *Settings, v any) { s.Init.AllowPrivilegeEscalation = ptr(v.(bool)) }},
+	{Key: "qtap-init-read-only-root-filesystem", Type: AnnotationType_BOOL, Container: "qtap-init",
+		apply: func(s *Settings, v any) { s.Init.ReadOnlyRootFilesystem = ptr(v.(bool)) }},
+	{Key: "qtap-init-seccomp-profile", Type: AnnotationType_ENUM, Container: "qtap-init", Default: string(corev1.SeccompProfileTypeRuntimeDefault),
+		Allowed: []string{string(corev1.SeccompProfileTypeRuntimeDefault), string(corev1.SeccompProfileTypeUnconfined)},
+		apply:   func(s *Settings, v any) { s.Init.SeccompProfile = corev1.SeccompProfileType(v.(string)) }},
+	{Key: "qtap-init-egress-to-addr", Type: AnnotationType_STRING, Container: "qtap-init", Env: "TO_ADDR",
+		apply: func(s *Settings, v any) { s.Init.EgressToAddr = v.(string) }},
+	{Key: "qtap-init-egress-to-domain", Type: AnnotationType_STRING, Container: "qtap-init", Env: "TO_DOMAIN",
+		apply: func(s *Settings, v any) { s.Init.EgressToDomain = v.(string) }},
+	{Key: "qtap-init-egress-port-mapping", Type: AnnotationType_PORT_MAPPING, Container: "qtap-init", Env: "PORT_MAPPING",
+		apply: func(s *Settings, v any) { s.Init.EgressPortMapping = v.(string) }},
+	{Key: "qtap-init-egress-accept-uids", Type: AnnotationType_ID_LIST, Container: "qtap-init", Env: "ACCEPT_UIDS"},
+	{Key: "qtap-init-egress-accept-gids", Type: AnnotationType_ID_LIST, Container: "qtap-init", Env: "ACCEPT_GIDS"},
+	{Key: "qtap-init-cpu-request", Type: AnnotationType_QUANTITY, Container: "qtap-init",
+		apply: func(s *Settings, v any) { setQuantity(&s.Init.Resources.Requests, corev1.ResourceCPU, v) }},
+	{Key: "qtap-init-cpu-limit", Type: AnnotationType_QUANTITY, Container: "qtap-init",
+		apply: func(s *Settings, v any) { setQuantity(&s.Init.Resources.Limits, corev1.ResourceCPU, v) }},
+	{Key: "qtap-init-memory-request", Type: AnnotationType_QUANTITY, Container: "qtap-init",
+		apply: func(s *Settings, v any) { setQuantity(&s.Init.Resources.Requests, corev1.ResourceMemory, v) }},
+	{Key: "qtap-init-memory-limit", Type: AnnotationType_QUANTITY, Container: "qtap-init",
+		apply: func(s *Settings, v any) { setQuantity(&s.Init.Resources.Limits, corev1.ResourceMemory, v) }},
+
+	// qtap
+	{Key: "token-secret", Type: AnnotationType_SECRET_NAME, Container: "qtap", Default: TOKEN_SECRET,
+		apply: func(s *Settings, v any) { s.Qtap.TokenSecret = v.(string) }},
+	{Key: "qtap-tag", Type: AnnotationType_IMAGE_TAG, Container: "qtap",
+		apply: func(s *Settings, v any) { s.Qtap.Tag = v.(string) }},
+	{Key: "qtap-repository", Type: AnnotationType_IMAGE_REPO, Container: "qtap",
+		apply: func(s *Settings, v any) { s.Qtap.Repository = v.(string) }},
+	{Key: "qtap-digest", Type: AnnotationType_IMAGE_DIGEST, Container: "qtap",
+		apply: func(s *Settings, v any) { s.Qtap.Digest = v.(string) }},
+	{Key: "qtap-uid", Type: AnnotationType_ID, Container: "qtap",
+		apply: func(s *Settings, v any) { s.Qtap.Uid = ptr(v.(int64)) }},
+	{Key: "qtap-gid", Type: AnnotationType_ID, Container: "qtap",
+		apply: func(s *Settings, v any) { s.Qtap.Gid = ptr(v.(int64)) }},
+	{Key: "qtap-run-as-non-root", Type: AnnotationType_BOOL, Container: "qtap",
+		apply: func(s *Settings, v any) { s.Qtap.RunAsNonRoot = ptr(v.(bool)) }},
+	{Key: "qtap-allow-privilege-escalation", Type: AnnotationType_BOOL, Container: "qtap", Default: "false",
+		apply: func(s *Settings, v any) { s.Qtap.AllowPrivilegeEscalation = ptr(v.(bool)) }},
+	{Key: "qtap-read-only-root-filesystem", Type: AnnotationType_BOOL, Container: "qtap", Default: "true",
+		apply: func(s *Settings, v any) { s.Qtap.ReadOnlyRootFilesystem = ptr(v.(bool)) }},
+	{Key: "qtap-seccomp-profile", Type: AnnotationType_ENUM, Container: "qtap", Default: string(corev1.SeccompProfileTypeRuntimeDefault),
+		Allowed: []string{string(corev1.SeccompProfileTypeRuntimeDefault), string(corev1.SeccompProfileTypeUnconfined)},
+		apply:   func(s *Settings, v any) { s.Qtap.SeccompProfile = corev1.SeccompProfileType(v.(string)) }},
+	{Key: "qtap-log-level", Type: AnnotationType_ENUM, Container: "qtap", Env: "LOG_LEVEL",
+		Allowed: []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"}},
+	{Key: "qtap-log-encoding", Type: AnnotationType_ENUM, Container: "qtap", Env: "LOG_ENCODING",
+		Allowed: []string{"json", "console"}},
+	{Key: "qtap-log-caller", Type: AnnotationType_BOOL, Container: "qtap", Env: "LOG_CALLER"},
+	{Key: "qtap-egress-http-listen", Type: AnnotationType_LISTEN_ADDRESS, Container: "qtap", Env: "EGRESS_HTTP_LISTEN",
+		apply: func(s *Settings, v any) { s.Qtap.EgressHttpListen = ptr(v.(ListenAddress)) }},
+	{Key: "qtap-egress-https-listen", Type: AnnotationType_LISTEN_ADDRESS, Container: "qtap", Env: "EGRESS_HTTPS_LISTEN",
+		apply: func(s *Settings, v any) { s.Qtap.EgressHttpsListen = ptr(v.(ListenAddress)) }},
+	{Key: "qtap-status-listen", Type: AnnotationType_LISTEN_ADDRESS, Container: "qtap", Env: "STATUS_LISTEN",
+		apply: func(s *Settings, v any) { s.Qtap.StatusPort = v.(ListenAddress).Port }},
+	{Key: "qtap-block-unknown", Type: AnnotationType_BOOL, Container: "qtap", Env: "BLOCK_UNKNOWN"},
+	{Key: "qtap-envoy-log-level", Type: AnnotationType_ENUM, Container: "qtap", Env: "ENVOY_LOG_LEVEL",
+		Allowed: []string{"trace", "debug", "info", "warning", "warn", "error", "critical", "off"}},
+	{Key: "qtap-dns-lookup-family", Type: AnnotationType_ENUM, Container: "qtap", Env: "DNS_LOOKUP_FAMILY",
+		Allowed: []string{"AUTO", "V4_ONLY", "V6_ONLY", "V4_PREFERRED", "ALL"}},
+	{Key: "qtap-api-endpoint", Type: AnnotationType_URL, Container: "qtap", Env: "ENDPOINT"},
+	{Key: "qtap-labels-tags-filter", Type: AnnotationType_REGEX_LIST, Container: "qtap",
+		apply: func(s *Settings, v any) { s.Qtap.TagsFilters = v.([]*regexp.Regexp) }},
+	{Key: "qtap-cpu-request", Type: AnnotationType_QUANTITY, Container: "qtap",
+		apply: func(s *Settings, v any) { setQuantity(&s.Qtap.Resources.Requests, corev1.ResourceCPU, v) }},
+	{Key: "qtap-cpu-limit", Type: AnnotationType_QUANTITY, Container: "qtap",
+		apply: func(s *Settings, v any) { setQuantity(&s.Qtap.Resources.Limits, corev1.ResourceCPU, v) }},
+	{Key: "qtap-memory-request", Type: AnnotationType_QUANTITY, Container: "qtap",
+		apply: func(s *Settings, v any) { setQuantity(&s.Qtap.Resources.Requests, corev1.ResourceMemory, v) }},
+	{Key: "qtap-memory-limit", Type: AnnotationType_QUANTITY, Container: "qtap",
+		apply: func(s *Settings, v any) { setQuantity(&s.Qtap.Resources.Limits, corev1.ResourceMemory, v) }},
+}
+
+// LookupAnnotation finds the registry entry for a fully qualified annotation name
+func LookupAnnotation(name string) *Annotation {
+	for i := range Annotations {
+		if Annotations[i].Name() == name {
+			return &Annotations[i]
+		}
+	}
+	return nil
+}
+
+// Settings are the typed values of the qpoint.io annotations of a pod
+type Settings struct {
+	InjectCa         bool
+	CaMode           CaMode
+	InjectCaJava     bool
+	CaEnv            []string
+	CaConflictPolicy ConflictPolicy
+	// the containers that receive the CA, every container when empty
+	CaContainers []string
+	// the init containers that receive the CA, none when empty
+	CaInitContainers []string
+	// the containers that are excluded from CA injection and egress routing
+	ExcludeContainers []string
+	ImagePullPolicy   corev1.PullPolicy
+	// where the proxy environment variables of the proxy-env egress mode point to
+	ProxyEnvTarget ProxyEnvTarget
+	// additional NO_PROXY entries of the proxy-env egress mode
+	ProxyEnvNoProxy []string
+	Init            InitSettings
+	Qtap            QtapSettings
+}
+
+// InitSettings configure the qtap-init container
+type InitSettings struct {
+	Tag                      string
+	Repository               string
+	Digest                   string
+	RunAsUser                *int64
+	RunAsGroup               *int64
+	RunAsNonRoot             *bool
+	Privileged               *bool
+	Capabilities             []corev1.Capability
+	AllowPrivilegeEscalation *bool
+	ReadOnlyRootFilesystem   *bool
+	SeccompProfile           corev1.SeccompProfileType
+	EgressToAddr             string
+	EgressToDomain           string
+	EgressPortMapping        string
+	Resources                corev1.ResourceRequirements
+	Env                      []corev1.EnvVar
+}
+
+// QtapSettings configure the qtap container
+type QtapSettings struct {
+	TokenSecret              string
+	Tag                      string
+	Repository               string
+	Digest                   string
+	Uid                      *int64
+	Gid                      *int64
+	RunAsNonRoot             *bool
+	AllowPrivilegeEscalation *bool
+	ReadOnlyRootFilesystem   *bool
+	SeccompProfile           corev1.SeccompProfileType
+	StatusPort               int32
+	EgressHttpListen         *ListenAddress
+	EgressHttpsListen        *ListenAddress
+	TagsFilters              []*regexp.Regexp
+	Resources                corev1.ResourceRequirements
+	Env                      []corev1.EnvVar
+}
+
+// ParseSettings validates every registered annotation and converts them into settings. All of the validation
+// errors are returned together so they can be reported in a single response.
+func ParseSettings(annotations map[string]string) (*Settings, field.ErrorList) {
+	settings := &Settings{
+		Qtap: QtapSettings{
+			StatusPort: DEFAULT_STATUS_PORT,
+		},
+	}
+
+	errs := field.ErrorList{}
+	path := field.NewPath("metadata", "annotations")
+
+	for i := range Annotations {
+		a := &Annotations[i]
+
+		value := annotations[a.Name()]
+		if value == "" {
+			value = a.Default
+		}
+		if value == "" {
+			continue
+		}
+
+		v, err := a.Parse(value)
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Key(a.Name()), value, err.Error()))
+			continue
+		}
+
+		if a.apply != nil {
+			a.apply(settings, v)
+		}
+
+		if a.Env != "" {
+			env := corev1.EnvVar{Name: a.Env, Value: value}
+			switch a.Container {
+			case "qtap-init":
+				settings.Init.Env = append(settings.Init.Env, env)
+			case "qtap":
+				settings.Qtap.Env = append(settings.Qtap.Env, env)
+			}
+		}
+	}
+
+	return settings, errs
+}
+
+func setQuantity(list *corev1.ResourceList, name corev1.ResourceName, v any) {
+	if *list == nil {
+		*list = corev1.ResourceList{}
+	}
+	(*list)[name] = v.(resource.Quantity)
+}
+
+// splitList splits a comma separated list, ignoring empty entries
+func splitList(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,144 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseSettingsDefaults(t *testing.T) {
+	settings, errs := ParseSettings(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs.ToAggregate())
+	}
+
+	if settings.InjectCa || settings.CaMode != CaMode_REPLACE || settings.CaConflictPolicy != ConflictPolicy_SKIP {
+		t.Errorf("ca settings %v, %s, %s, want the defaults", settings.InjectCa, settings.CaMode, settings.CaConflictPolicy)
+	}
+	if settings.ProxyEnvTarget != ProxyEnvTarget_SIDECAR {
+		t.Errorf("proxy env target %s, want %s", settings.ProxyEnvTarget, ProxyEnvTarget_SIDECAR)
+	}
+	if !reflect.DeepEqual(settings.Init.Capabilities, []corev1.Capability{"NET_ADMIN", "NET_RAW"}) {
+		t.Errorf("qtap-init capabilities %v, want NET_ADMIN and NET_RAW", settings.Init.Capabilities)
+	}
+	if settings.Qtap.TokenSecret != TOKEN_SECRET || settings.Qtap.StatusPort != DEFAULT_STATUS_PORT {
+		t.Errorf("qtap token secret %s and status port %d, want the defaults", settings.Qtap.TokenSecret, settings.Qtap.StatusPort)
+	}
+	if settings.Qtap.ReadOnlyRootFilesystem == nil || !*settings.Qtap.ReadOnlyRootFilesystem {
+		t.Errorf("qtap read-only root filesystem %v, want true", settings.Qtap.ReadOnlyRootFilesystem)
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	settings, errs := ParseSettings(map[string]string{
+		"qpoint.io/inject-ca":                     "true",
+		"qpoint.io/inject-ca-env":                 "node, python",
+		"qpoint.io/exclude-containers":            "sidecar",
+		"qpoint.io/qtap-init-run-as-user":         "0",
+		"qpoint.io/qtap-init-capabilities":        "CAP_NET_ADMIN",
+		"qpoint.io/qtap-init-egress-port-mapping": "10080:80,10443:443",
+		"qpoint.io/qtap-uid":                      "1010",
+		"qpoint.io/qtap-status-listen":            "0.0.0.0:9000",
+		"qpoint.io/qtap-log-level":                "debug",
+		"qpoint.io/qtap-cpu-limit":                "500m",
+		"qpoint.io/qtap-labels-tags-filter":       "^app$",
+		"qpoint.io/unknown":                       "ignored",
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs.ToAggregate())
+	}
+
+	if !settings.InjectCa || !reflect.DeepEqual(settings.CaEnv, []string{"node", "python"}) {
+		t.Errorf("ca settings %v and %v", settings.InjectCa, settings.CaEnv)
+	}
+	if !reflect.DeepEqual(settings.ExcludeContainers, []string{"sidecar"}) {
+		t.Errorf("excluded containers %v", settings.ExcludeContainers)
+	}
+	if settings.Init.RunAsUser == nil || *settings.Init.RunAsUser != 0 {
+		t.Errorf("qtap-init run as user %v, want 0", settings.Init.RunAsUser)
+	}
+	if !reflect.DeepEqual(settings.Init.Capabilities, []corev1.Capability{"NET_ADMIN"}) {
+		t.Errorf("qtap-init capabilities %v, want NET_ADMIN without the prefix", settings.Init.Capabilities)
+	}
+	if settings.Qtap.Uid == nil || *settings.Qtap.Uid != 1010 || settings.Qtap.StatusPort != 9000 {
+		t.Errorf("qtap uid %v and status port %d", settings.Qtap.Uid, settings.Qtap.StatusPort)
+	}
+	if limit := settings.Qtap.Resources.Limits[corev1.ResourceCPU]; limit.String() != "500m" {
+		t.Errorf("qtap cpu limit %s, want 500m", limit.String())
+	}
+	if len(settings.Qtap.TagsFilters) != 1 || !settings.Qtap.TagsFilters[0].MatchString("app") {
+		t.Errorf("qtap tags filters %v", settings.Qtap.TagsFilters)
+	}
+
+	// the annotations with an environment variable are passed to their container
+	if !hasEnvValue(settings.Init.Env, "PORT_MAPPING", "10080:80,10443:443") {
+		t.Errorf("qtap-init env %v lacks PORT_MAPPING", settings.Init.Env)
+	}
+	if !hasEnvValue(settings.Qtap.Env, "LOG_LEVEL", "debug") || !hasEnvValue(settings.Qtap.Env, "STATUS_LISTEN", "0.0.0.0:9000") {
+		t.Errorf("qtap env %v lacks LOG_LEVEL or STATUS_LISTEN", settings.Qtap.Env)
+	}
+}
+
+func TestParseSettingsInvalid(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{key: "inject-ca", value: "yes please"},
+		{key: "inject-ca-mode", value: "append"},
+		{key: "inject-ca-env", value: "node,cobol"},
+		{key: "exclude-containers", value: "Not_A_Name"},
+		{key: "token-secret", value: "UPPER"},
+		{key: "qtap-init-tag", value: "-latest"},
+		{key: "qtap-init-repository", value: "registry.example.com/qtap:latest"},
+		{key: "qtap-init-digest", value: "sha256:abc"},
+		{key: "qtap-init-run-as-user", value: "-1"},
+		{key: "qtap-init-run-as-group", value: "root"},
+		{key: "qtap-init-capabilities", value: "net_admin"},
+		{key: "qtap-init-egress-port-mapping", value: "10080"},
+		{key: "qtap-init-egress-port-mapping", value: "10080:0"},
+		{key: "qtap-init-egress-accept-uids", value: "1000,abc"},
+		{key: "qtap-init-cpu-request", value: "-100m"},
+		{key: "qtap-egress-http-listen", value: "10080"},
+		{key: "qtap-egress-http-listen", value: "example.com:10080"},
+		{key: "qtap-status-listen", value: "0.0.0.0:70000"},
+		{key: "qtap-log-level", value: "verbose"},
+		{key: "qtap-api-endpoint", value: "ftp://api.example.com"},
+		{key: "qtap-labels-tags-filter", value: "app,(unclosed"},
+		{key: "qtap-memory-limit", value: "lots"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			name := ANNOTATION_PREFIX + tt.key
+			_, errs := ParseSettings(map[string]string{name: tt.value})
+			if len(errs) != 1 {
+				t.Fatalf("errors %v, want one", errs)
+			}
+			if want := "metadata.annotations[" + name + "]"; errs[0].Field != want {
+				t.Errorf("error on %s, want %s", errs[0].Field, want)
+			}
+		})
+	}
+}
+
+func TestParseSettingsReportsEveryError(t *testing.T) {
+	_, errs := ParseSettings(map[string]string{
+		"qpoint.io/inject-ca":          "maybe",
+		"qpoint.io/qtap-uid":           "root",
+		"qpoint.io/qtap-status-listen": "nowhere",
+	})
+	if len(errs) != 3 {
+		t.Fatalf("errors %v, want three", errs)
+	}
+}
+
+func hasEnvValue(env []corev1.EnvVar, name string, value string) bool {
+	for _, e := range env {
+		if e.Name == name && e.Value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -27,20 +27,29 @@ for bundle; do
   if [ -f "$bundle" ]; then
     echo "Found ca bundle: ${bundle}"
 
-    # extract the directly/file from the bundle
-    directory=$(dirname "$bundle")
+    # extract the file from the bundle
     file=$(basename "$bundle")
 
-    # copy the contents of the bundle into the shared mount
-    echo "Copying contents of $directory to $destination"
-    cp -R "$directory"/* "$destination"
-
-    # append the qpoint root ca
+    # copy the contents of the bundle (following symlinks) into the shared mount
+    # and append the qpoint root ca
+    echo "Merging $bundle into $destination/$file"
+    cat "$bundle" > "$destination/$file"
+    echo >> "$destination/$file"
     cat "$qpoint_ca" >> "$destination/$file"
 
     # leave the breadcrumb
     config_copied="yes"
 
+    # the merged bundle is mounted over each of the bundle locations, so it
+    # needs to exist under each of their file names
+    set -- $bundles
+    for other; do
+      other_file=$(basename "$other")
+      if [ "$other_file" != "$file" ]; then
+        cp "$destination/$file" "$destination/$other_file"
+      fi
+    done
+
     # stop after the first found bundle
     break
   fi
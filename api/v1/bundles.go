@@ -0,0 +1,127 @@
+package v1
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// where a base bundle was read from
+const (
+	BaseBundleSource_CONFIGMAP = "configmap"
+	BaseBundleSource_DIR       = "dir"
+	BaseBundleSource_EMBEDDED  = "embedded"
+)
+
+// the distribution bundles within the qtap-ca-bundle.crt configmap along with the copy embedded in the operator
+var embeddedBaseBundles = []BaseBundle{
+	{Key: "alpine-cert.pem", Source: BaseBundleSource_EMBEDDED, Data: alpineCertPem},
+	{Key: "fedora-ca-bundle.crt", Source: BaseBundleSource_EMBEDDED, Data: fedoraCaBundle},
+	{Key: "ubuntu-ca-certificates.crt", Source: BaseBundleSource_EMBEDDED, Data: ubuntuCaCertificates},
+}
+
+// BaseBundle is a distribution trust store the Qpoint CAs are appended to
+type BaseBundle struct {
+	Key    string
+	Source string
+	Data   string
+}
+
+// BaseBundleSource resolves the base bundles. Each bundle is read from the configmap in the operator namespace
+// or the directory (such as a mounted configmap) using the same key as the qtap-ca-bundle.crt configmap. The
+// bundles embedded in the operator are used for any bundle that is not provided.
+type BaseBundleSource struct {
+	Client            client.Client
+	OperatorNamespace string
+	ConfigMapName     string
+	Dir               string
+}
+
+func (s *BaseBundleSource) Get(ctx context.Context) ([]BaseBundle, error) {
+	configMapData := map[string]string{}
+	if s.ConfigMapName != "" {
+		configMap := &corev1.ConfigMap{}
+		if err := s.Client.Get(ctx, client.ObjectKey{Namespace: s.OperatorNamespace, Name: s.ConfigMapName}, configMap); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("retrieving base CA bundles config map: %w", err)
+			}
+		}
+		configMapData = configMap.Data
+	}
+
+	bundles := make([]BaseBundle, 0, len(embeddedBaseBundles))
+	for _, embedded := range embeddedBaseBundles {
+		bundle := embedded
+
+		if data, exists := configMapData[bundle.Key]; exists {
+			bundle = BaseBundle{Key: bundle.Key, Source: BaseBundleSource_CONFIGMAP, Data: data}
+		} else if s.Dir != "" {
+			data, err := os.ReadFile(filepath.Join(s.Dir, bundle.Key))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("reading base CA bundle '%s': %w", bundle.Key, err)
+			}
+			if err == nil {
+				bundle = BaseBundle{Key: bundle.Key, Source: BaseBundleSource_DIR, Data: string(data)}
+			}
+		}
+
+		// an empty or corrupt bundle would leave the pods without any trusted CAs other than Qpoint's
+		if bundle.Source != BaseBundleSource_EMBEDDED {
+			if stats := InspectBundle(bundle.Data, time.Now()); stats.Certificates == 0 {
+				return nil, fmt.Errorf("base CA bundle '%s' from %s contains no certificates", bundle.Key, bundle.Source)
+			}
+		}
+
+		bundles = append(bundles, bundle)
+	}
+
+	return bundles, nil
+}
+
+// BundleStats summarize the certificates within a bundle
+type BundleStats struct {
+	Certificates int
+	Expired      int
+	// the time the most recently issued certificate was issued, which indicates how stale a bundle is
+	NewestIssued time.Time
+}
+
+// InspectBundle counts the certificates of a PEM bundle. Blocks that can't be parsed are skipped.
+func InspectBundle(pemData string, now time.Time) BundleStats {
+	stats := BundleStats{}
+
+	rest := []byte(pemData)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+
+		stats.Certificates++
+		if now.After(cert.NotAfter) {
+			stats.Expired++
+		}
+		if cert.NotBefore.After(stats.NewestIssued) {
+			stats.NewestIssued = cert.NotBefore
+		}
+	}
+
+	return stats
+}
@@ -1,25 +1,80 @@
 package v1
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const QTAP_BUNDLE = "qtap-ca-bundle.crt"    // final bundle includes all CAs (including Qpoint's CA)
 const QPOINT_ROOT_CA = "qpoint-qtap-ca.crt" // this is the Qpoint CA
 const DEFAULT_ENDPOINT = "https://api.qpoint.io"
+const CA_FINGERPRINT_ANNOTATION = "qpoint.io/ca-fingerprint" // fingerprints of the CAs appended to a bundle
+const QPOINT_CA_KEY = "qpoint-ca.pem"                        // the appended CAs on their own within a bundle
+
+// CaMode determines how the Qpoint CA is made available to the application containers
+type CaMode string
+
+const (
+	// the trust store of the containers is replaced with the bundles maintained by the operator
+	CaMode_REPLACE CaMode = "replace"
+	// the Qpoint CA is appended to the trust store of the application image by an init container
+	CaMode_MERGE CaMode = "merge"
+)
+
+// where the qtap-ca-bundle.crt configmap is mounted for the runtime environment variables
+const CA_BUNDLE_DIR = "/var/run/qpoint/ca"
+
+// a complete bundle within the configmap in the PEM format every runtime reads
+const CA_ENV_BUNDLE = "ubuntu-ca-certificates.crt"
+
+// the environment variables that point each runtime at a CA bundle. Variables that replace the trust store
+// point at a complete bundle, NODE_EXTRA_CA_CERTS only adds to the roots node ships with.
+var caEnvRuntimes = []struct {
+	Name string
+	Env  map[string]string
+}{
+	{Name: "node", Env: map[string]string{"NODE_EXTRA_CA_CERTS": QPOINT_CA_KEY}},
+	{Name: "python", Env: map[string]string{"REQUESTS_CA_BUNDLE": CA_ENV_BUNDLE, "SSL_CERT_FILE": CA_ENV_BUNDLE}},
+	{Name: "ruby", Env: map[string]string{"SSL_CERT_FILE": CA_ENV_BUNDLE}},
+	{Name: "curl", Env: map[string]string{"CURL_CA_BUNDLE": CA_ENV_BUNDLE}},
+	{Name: "openssl", Env: map[string]string{"SSL_CERT_FILE": CA_ENV_BUNDLE}},
+	{Name: "git", Env: map[string]string{"GIT_SSL_CAINFO": CA_ENV_BUNDLE}},
+	{Name: "aws", Env: map[string]string{"AWS_CA_BUNDLE": CA_ENV_BUNDLE}},
+}
+
+func caEnvRuntimeNames() []string {
+	names := []string{}
+	for _, runtime := range caEnvRuntimes {
+		names = append(names, runtime.Name)
+	}
+	return names
+}
+
+// the candidate trust store locations of the common distributions, these match the bundles in build-ca.sh
+var caBundlePaths = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
 
 type Registration struct {
-	Ca string `json:"ca"`
+	Ca  string           `json:"ca"`
+	Cas []TrustedCaEntry `json:"cas,omitempty"`
 }
 
 type RegistrationResponse struct {
@@ -78,7 +133,34 @@ var fedoraCaBundle string
 //go:embed assets/ubuntu-ca-certificates.crt
 var ubuntuCaCertificates string
 
+// MutateCa applies the CA injection settings of the pod
+func MutateCa(pod *corev1.Pod, config *Config) error {
+	if config.InjectCa {
+		if err := MutateCaInjection(pod, config); err != nil {
+			return fmt.Errorf("mutating pod for ca injection: %w", err)
+		}
+	}
+
+	if config.Settings.InjectCaJava {
+		if err := MutateJavaTrustStore(pod, config); err != nil {
+			return fmt.Errorf("mutating pod for java truststore injection: %w", err)
+		}
+	}
+
+	if len(config.Settings.CaEnv) > 0 {
+		if err := MutateCaEnv(pod, config); err != nil {
+			return fmt.Errorf("mutating pod for ca environment variables: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func MutateCaInjection(pod *corev1.Pod, config *Config) error {
+	if config.Settings.CaMode == CaMode_MERGE {
+		return mutateCaMerge(pod, config)
+	}
+
 	// generate a volume from the configmap
 	configMapVolume := corev1.Volume{
 		Name: "qtap-ca-bundle-volume",
@@ -97,7 +179,7 @@ func MutateCaInjection(pod *corev1.Pod, config *Config) error {
 	}
 
 	// add the volumes to the pod
-	pod.Spec.Volumes = append(pod.Spec.Volumes, configMapVolume)
+	pod.Spec.Volumes = upsertVolume(pod.Spec.Volumes, configMapVolume)
 
 	// define the volume mounts
 	volumeMounts := []corev1.VolumeMount{}
@@ -123,102 +205,315 @@ func MutateCaInjection(pod *corev1.Pod, config *Config) error {
 		SubPath:   "ubuntu-ca-certificates.crt",
 	})
 
-	// add mounts to the containers
-	for i := range pod.Spec.Containers {
+	// add mounts to the selected containers
+	for _, container := range caContainers(pod, config.Settings) {
 		// ensure volume mounts have been initialized
-		if pod.Spec.Containers[i].VolumeMounts == nil {
-			pod.Spec.Containers[i].VolumeMounts = make([]corev1.VolumeMount, 0)
+		if container.VolumeMounts == nil {
+			container.VolumeMounts = make([]corev1.VolumeMount, 0)
 		}
 
 		// append
-		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, volumeMounts...)
+		for _, volumeMount := range volumeMounts {
+			if err := addVolumeMount(container, volumeMount, config.Settings.CaConflictPolicy); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-func EnsureAssetsInNamespace(config *Config) error {
-	// the goal is to ensure this exists already or we'll create it
-	qtapCaBundleExists := false
+// mutateCaMerge adds an init container that runs build-ca.sh with the image of the application. The script
+// appends the Qpoint CA to the trust store found in the image and writes the result to a shared volume, which
+// is then mounted over each of the candidate trust store locations of the application containers. Unlike the
+// replace mode this keeps any CAs the image added to its own trust store.
+func mutateCaMerge(pod *corev1.Pod, config *Config) error {
+	// the script runs in the filesystem of the application
+	source := caMergeSource(pod, config.Settings)
+	if source == nil {
+		return fmt.Errorf("no application container to build the CA bundle from")
+	}
+
+	// the Qpoint CA from the bundle configmap and the shared volume the merged bundle is written to
+	pod.Spec.Volumes = upsertVolume(pod.Spec.Volumes, corev1.Volume{
+		Name: "qtap-ca-bundle-volume",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: QTAP_BUNDLE,
+				},
+			},
+		},
+	})
+	pod.Spec.Volumes = upsertVolume(pod.Spec.Volumes, corev1.Volume{
+		Name: "qtap-ca-merged-volume",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+
+	initContainer := corev1.Container{
+		Name:            "qtap-ca-init",
+		Image:           source.Image,
+		Command:         []string{"sh", "-c", buildCaScript},
+		SecurityContext: caMergeSecurityContext(source),
+		// only the defaults of the namespace, which keep the pod admissible with a ResourceQuota
+		Resources: config.containerResources("qtap-ca-init", corev1.ResourceRequirements{}),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "qtap-ca-bundle-volume",
+				MountPath: "/mnt/ca/qpoint.pem",
+				SubPath:   QPOINT_CA_KEY,
+				ReadOnly:  true,
+			},
+			{
+				Name:      "qtap-ca-merged-volume",
+				MountPath: "/mnt/tls",
+			},
+		},
+	}
 
-	// qtap ca configmap reference
-	qtapCaConfigMap := &corev1.ConfigMap{}
-	qtapCaRef := client.ObjectKey{Namespace: config.Namespace, Name: QTAP_BUNDLE}
+	// prepend to the list (or replace a qtap-ca-init container that already exists)
+	pod.Spec.InitContainers = upsertContainer(pod.Spec.InitContainers, initContainer)
 
-	// try to load the qtap ca configmap
-	if err := config.Client.Get(config.Ctx, qtapCaRef, qtapCaConfigMap); err != nil {
-		if !apierrors.IsNotFound(err) {
-			return fmt.Errorf("retrieving Qtap CA config map: %w", err)
+	// the script writes the merged bundle under the file name of every candidate location
+	for _, container := range caContainers(pod, config.Settings) {
+		if container.Name == "qtap-ca-init" {
+			continue
+		}
+
+		for _, path := range caBundlePaths {
+			volumeMount := corev1.VolumeMount{
+				Name:      "qtap-ca-merged-volume",
+				MountPath: path,
+				SubPath:   filepath.Base(path),
+				ReadOnly:  true,
+			}
+			if err := addVolumeMount(container, volumeMount, config.Settings.CaConflictPolicy); err != nil {
+				return err
+			}
 		}
-	} else {
-		qtapCaBundleExists = true
 	}
 
-	// if the qtap ca bundle already exists, we're gtg
-	if qtapCaBundleExists {
-		return nil
+	return nil
+}
+
+// caMergeSource is the container whose image the merge mode builds the CA bundle with, the first selected
+// container that isn't injected by the operator
+func caMergeSource(pod *corev1.Pod, settings *Settings) *corev1.Container {
+	for _, container := range caContainers(pod, settings) {
+		if container.Name != "qtap" && container.Name != "qtap-ca-init" {
+			return container
+		}
 	}
+	return nil
+}
 
-	// it is possible that many mutations are requested in succession and this can lead to an "already exists"
-	// for the create operation. Thus, synchronize around the possible creation by only allowing one of this function
-	// to execute at any given time
-	mu := sync.Mutex{}
-	mu.Lock()
-	defer mu.Unlock()
+// caMergeSecurityContext runs qtap-ca-init as the user of the application container it takes the image from,
+// with the same hardening as the other injected containers. The script only reads the trust store of the image
+// and writes to an emptyDir, so it needs no capabilities.
+func caMergeSecurityContext(source *corev1.Container) *corev1.SecurityContext {
+	securityContext := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: ptr(false),
+		SeccompProfile:           seccompProfile(corev1.SeccompProfileTypeRuntimeDefault),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+	if sc := source.SecurityContext; sc != nil {
+		securityContext.RunAsUser = sc.RunAsUser
+		securityContext.RunAsGroup = sc.RunAsGroup
+		securityContext.RunAsNonRoot = sc.RunAsNonRoot
+	}
+	return securityContext
+}
 
+// MutateCaEnv mounts the qtap-ca-bundle.crt configmap into the application containers and sets the CA
+// environment variables of the selected runtimes. Many runtimes bypass the system trust store (node ships its
+// own roots, python requests uses certifi) and only trust the Qpoint CA through these. Variables the container
+// already sets are left as is.
+func MutateCaEnv(pod *corev1.Pod, config *Config) error {
+	pod.Spec.Volumes = upsertVolume(pod.Spec.Volumes, corev1.Volume{
+		Name: "qtap-ca-bundle-volume",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: QTAP_BUNDLE,
+				},
+			},
+		},
+	})
+
+	// the directory is mounted rather than the files so that the kubelet keeps the bundles up to date
+	volumeMount := corev1.VolumeMount{
+		Name:      "qtap-ca-bundle-volume",
+		MountPath: CA_BUNDLE_DIR,
+		ReadOnly:  true,
+	}
+
+	env := []corev1.EnvVar{}
+	for _, runtime := range caEnvRuntimes {
+		selected := false
+		for _, name := range config.Settings.CaEnv {
+			selected = selected || name == runtime.Name
+		}
+		if !selected {
+			continue
+		}
+
+		for _, name := range sortedKeys(runtime.Env) {
+			env = setEnvDefault(env, name, filepath.Join(CA_BUNDLE_DIR, runtime.Env[name]))
+		}
+	}
+
+	for _, container := range caContainers(pod, config.Settings) {
+		if err := addVolumeMount(container, volumeMount, config.Settings.CaConflictPolicy); err != nil {
+			return err
+		}
+		for _, e := range env {
+			container.Env = setEnvDefault(container.Env, e.Name, e.Value)
+		}
+	}
+
+	return nil
+}
+
+// CaSource resolves the Qpoint CAs. The CAs are read from the qpoint-qtap-ca.crt configmap in the operator
+// namespace or, if it doesn't exist, fetched from the registration API with the given token. Registrations are
+// cached per token for the refresh interval so that the API isn't queried for every namespace.
+//
+// Both sources can list several CAs along with the period they should be trusted for, which allows a new CA
+// to be introduced before the previous one is retired. The configmap lists them in the ca.crt key (trusted for
+// the validity of each certificate) and/or the cas.yaml key (a list of ca, notBefore and notAfter).
+type CaSource struct {
+	Client            client.Client
+	OperatorNamespace string
+	RefreshInterval   time.Duration
+
+	mu            sync.Mutex
+	registrations map[string]*cachedRegistration
+}
+
+type cachedRegistration struct {
+	registration *Registration
+	fetchedAt    time.Time
+}
+
+func (s *CaSource) Get(ctx context.Context, tokenSecret string) ([]TrustedCa, error) {
 	// we need to see if we have the qtap ca in the operator namespace
 	qpointRootCaConfigMap := &corev1.ConfigMap{}
-	if err := config.Client.Get(config.Ctx, client.ObjectKey{Namespace: config.OperatorNamespace, Name: QPOINT_ROOT_CA}, qpointRootCaConfigMap); err != nil {
-		if apierrors.IsNotFound(err) {
-			// the config map wasn't found and so we'll attempt to fetch the CA from the API
-			// this involves fetching the token secret for accessing the API
-			secret := &corev1.Secret{}
-			if err := config.Client.Get(config.Ctx, client.ObjectKey{Name: "token", Namespace: config.OperatorNamespace}, secret); err != nil {
-				return fmt.Errorf("fetching secret '%s' at namespace '%s' from the api: %w", "token", config.OperatorNamespace, err)
-			}
+	if err := s.Client.Get(ctx, client.ObjectKey{Namespace: s.OperatorNamespace, Name: QPOINT_ROOT_CA}, qpointRootCaConfigMap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("retrieving Qtap CA config map: %w", err)
+		}
 
-			tokenBytes, exists := secret.Data["token"]
-			if !exists {
-				return fmt.Errorf("token not found in secret '%s'", "token")
-			}
+		// the config map wasn't found and so we'll attempt to fetch the CA from the API
+		registration, err := s.fetchRegistration(ctx, tokenSecret)
+		if err != nil {
+			return nil, fmt.Errorf("missing configuration for Qpoint Root CA, check instructions: %w", err)
+		}
 
-			// convert the []byte data to a string
-			token := string(tokenBytes)
-
-			if registration, err := FetchRegistration(token); err == nil {
-				// the data gets set as if the config map was able to be fetched even though it was
-				// obtained from the API
-				qpointRootCaConfigMap.Data = map[string]string{
-					"ca.crt": registration.Ca,
-				}
-			} else {
-				return fmt.Errorf("missing configuration for Qpoint Root CA, check instructions")
-			}
-		} else {
-			return fmt.Errorf("retrieving Qtap CA config map: %w", err)
+		return registrationCas(registration)
+	}
+
+	return configMapCas(qpointRootCaConfigMap)
+}
+
+func configMapCas(configMap *corev1.ConfigMap) ([]TrustedCa, error) {
+	cas := []TrustedCa{}
+
+	if data := configMap.Data["ca.crt"]; data != "" {
+		parsed, err := ParseTrustedCas(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing 'ca.crt' of configmap '%s': %w", configMap.Name, err)
 		}
+		cas = parsed
 	}
 
-	// extract the root CA
-	qpointRootCa := qpointRootCaConfigMap.Data["ca.crt"]
+	if data := configMap.Data["cas.yaml"]; data != "" {
+		parsed, err := ParseTrustedCaEntries(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing 'cas.yaml' of configmap '%s': %w", configMap.Name, err)
+		}
+		cas = MergeTrustedCas(cas, parsed)
+	}
 
-	// construct the config map
-	qtapCaConfigMap = &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      QTAP_BUNDLE,
-			Namespace: config.Namespace,
-		},
-		Data: map[string]string{
-			"alpine-cert.pem":            fmt.Sprintf("%s%s\n", alpineCertPem, qpointRootCa),
-			"fedora-ca-bundle.crt":       fmt.Sprintf("%s%s\n", fedoraCaBundle, qpointRootCa),
-			"ubuntu-ca-certificates.crt": fmt.Sprintf("%s%s\n", ubuntuCaCertificates, qpointRootCa),
-		},
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("configmap '%s' has no CAs", configMap.Name)
 	}
 
-	// create it
-	if err := config.Client.Create(config.Ctx, qtapCaConfigMap); err != nil {
-		return fmt.Errorf("creating configmap for Qtap CA bundles: %w", err)
+	return cas, nil
+}
+
+func registrationCas(registration *Registration) ([]TrustedCa, error) {
+	cas := []TrustedCa{}
+
+	if registration.Ca != "" {
+		parsed, err := ParseTrustedCas(registration.Ca)
+		if err != nil {
+			return nil, fmt.Errorf("parsing registration CA: %w", err)
+		}
+		cas = parsed
 	}
 
-	return nil
+	if len(registration.Cas) > 0 {
+		parsed, err := entriesToTrustedCas(registration.Cas)
+		if err != nil {
+			return nil, fmt.Errorf("parsing registration CAs: %w", err)
+		}
+		cas = MergeTrustedCas(cas, parsed)
+	}
+
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("registration has no CAs")
+	}
+
+	return cas, nil
+}
+
+func (s *CaSource) fetchRegistration(ctx context.Context, tokenSecret string) (*Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached, exists := s.registrations[tokenSecret]; exists && time.Since(cached.fetchedAt) < s.RefreshInterval {
+		return cached.registration, nil
+	}
+
+	// fetching the CA from the API involves fetching the token secret for accessing the API
+	secret := &corev1.Secret{}
+	if err := s.Client.Get(ctx, client.ObjectKey{Name: tokenSecret, Namespace: s.OperatorNamespace}, secret); err != nil {
+		return nil, fmt.Errorf("fetching secret '%s' at namespace '%s' from the api: %w", tokenSecret, s.OperatorNamespace, err)
+	}
+
+	tokenBytes, exists := secret.Data[TOKEN_KEY]
+	if !exists {
+		return nil, fmt.Errorf("token not found in secret '%s'", tokenSecret)
+	}
+
+	registration, err := FetchRegistration(string(tokenBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.registrations == nil {
+		s.registrations = make(map[string]*cachedRegistration)
+	}
+	s.registrations[tokenSecret] = &cachedRegistration{registration: registration, fetchedAt: time.Now()}
+
+	return registration, nil
+}
+
+// BuildCaBundle builds the data of the qtap-ca-bundle.crt configmap by appending the extra CAs and the Qpoint
+// CAs to each of the base bundles. Extra CAs that are also Qpoint CAs are only appended once.
+func BuildCaBundle(baseBundles []BaseBundle, qpointCas []TrustedCa, extraCas []TrustedCa) map[string]string {
+	cas := JoinTrustedCas(MergeTrustedCas(extraCas, qpointCas))
+
+	data := map[string]string{
+		QPOINT_CA_KEY: fmt.Sprintf("%s\n", cas),
+	}
+	for _, base := range baseBundles {
+		data[base.Key] = fmt.Sprintf("%s\n%s\n", strings.TrimRight(base.Data, "\n"), cas)
+	}
+
+	return data
 }
@@ -3,14 +3,20 @@ package v1
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 )
 
 const SERVICE_ANNOTATIONS_CONFIGMAP = "qtap-operator-service-pod-annotations-configmap"
 const INJECT_ANNOTATIONS_CONFIGMAP = "qtap-operator-inject-pod-annotations-configmap"
+const PROXY_ENV_ANNOTATIONS_CONFIGMAP = "qtap-operator-proxy-env-pod-annotations-configmap"
 const NAMESPACE_EGRESS_LABEL = "qpoint.io/egress"
 const POD_EGRESS_LABEL = "qpoint.io/egress"
 
@@ -21,6 +27,7 @@ const (
 	EgressType_DISABLE   EgressType = "disable"
 	EgressType_SERVICE   EgressType = "service"
 	EgressType_INJECT    EgressType = "inject"
+	EgressType_PROXY_ENV EgressType = "proxy-env"
 )
 
 type Config struct {
@@ -30,14 +37,26 @@ type Config struct {
 	OperatorNamespace string
 	Client            client.Client
 	Ctx               context.Context
+	Settings          *Settings
+	Overrides         *OverridePolicy
+	NativeSidecars    bool
+	InitRepository    string
+	QtapRepository    string
+	ImagePullSecrets  []string
+	Warnings          []string
 	annotations       map[string]string
+	policy            *QtapEgressPolicySpec
+	resourceDefaults  corev1.ResourceRequirements
+	resourceMax       corev1.ResourceList
+	proxyEnv          []corev1.EnvVar
 }
 
 // Config scenarios:
 // a) Egress routing is enabled and gateway is disabled via the namespace label or pod label. This means that the egress traffic is being routed to the qtap service running somewhere else in the cluster.
 // b) Egress routing is enabled and gateway is enabled via the namespace label or pod label. This means that the egress traffic is being routed through the qtap sidecar proxy.
+// c) Egress routing is enabled with proxy environment variables via the namespace label or pod label. This means that the egress traffic of clients honoring HTTP_PROXY/HTTPS_PROXY goes to the qtap sidecar or service.
 //
-// Egress routing is always controlled by the qtap-init container which manipulates iptables rules for routing egress traffic to one of the above qtap setups.
+// Egress routing is controlled by the qtap-init container which manipulates iptables rules for routing egress traffic to one of the above qtap setups, except for c) which needs no qtap-init container.
 
 func (c *Config) Init(pod *corev1.Pod) error {
 	// first check if the namespace has the label. If it does then assume that egress is enabled
@@ -61,10 +80,22 @@ func (c *Config) Init(pod *corev1.Pod) error {
 		c.EgressType = EgressType_INJECT
 		namespaceEgressType = EgressType_INJECT
 		configMapName = INJECT_ANNOTATIONS_CONFIGMAP
+	case EgressType_PROXY_ENV:
+		c.EgressType = EgressType_PROXY_ENV
+		namespaceEgressType = EgressType_PROXY_ENV
+		configMapName = PROXY_ENV_ANNOTATIONS_CONFIGMAP
 	}
 
 	podEgressType := EgressType_UNDEFINED
 
+	// when egress is enforced for the namespace only the exempted pods may disable it, or switch to a mode qtap
+	// only sees part of the egress in
+	if namespaceEgressType != EgressType_UNDEFINED {
+		if err := c.checkEgressOptOut(namespace, pod, egressLabelOptOuts(namespaceEgressType, pod)); err != nil {
+			return err
+		}
+	}
+
 	// order matters as pods override namespaces
 
 	switch v := pod.Labels[POD_EGRESS_LABEL]; EgressType(v) {
@@ -79,6 +110,10 @@ func (c *Config) Init(pod *corev1.Pod) error {
 		c.EgressType = EgressType_INJECT
 		podEgressType = EgressType_INJECT
 		configMapName = INJECT_ANNOTATIONS_CONFIGMAP
+	case EgressType_PROXY_ENV:
+		c.EgressType = EgressType_PROXY_ENV
+		podEgressType = EgressType_PROXY_ENV
+		configMapName = PROXY_ENV_ANNOTATIONS_CONFIGMAP
 	}
 
 	// egress is undefined for the entire namespace (regardless of what the pod label says) or pod and thus return immediately
@@ -88,16 +123,31 @@ func (c *Config) Init(pod *corev1.Pod) error {
 	}
 
 	if configMapName != "" {
-		// let's fetch the default settings in the configmap
-		configMap := &corev1.ConfigMap{}
-		if err := c.Client.Get(c.Ctx, client.ObjectKey{Name: configMapName, Namespace: c.OperatorNamespace}, configMap); err != nil {
-			return fmt.Errorf("fetching configmap '%s' at namespace '%s' from the api: %w", configMapName, c.OperatorNamespace, err)
+		// let's resolve the default settings from the policies (or the configmap as a fallback)
+		defaultAnnotations, err := c.defaultAnnotations(configMapName)
+		if err != nil {
+			return err
+		}
+
+		// the token secret of the namespace takes precedence over the defaults. The validating webhook checking
+		// namespaces fails open, so whether the namespace may override it is checked again here.
+		if tokenSecret := namespace.Annotations[TOKEN_SECRET_ANNOTATION]; tokenSecret != "" {
+			if errs := c.Overrides.Check("Namespace", map[string]string{TOKEN_SECRET_ANNOTATION: tokenSecret}, defaultAnnotations); len(errs) > 0 {
+				return apierrors.NewInvalid(schema.GroupKind{Kind: "Namespace"}, namespace.Name, errs)
+			}
+			defaultAnnotations[TOKEN_SECRET_ANNOTATION] = tokenSecret
+		}
+
+		// nor may it exempt containers from the egress of an enforced namespace
+		if namespaceEgressType != EgressType_UNDEFINED {
+			if err := c.checkEgressOptOut(namespace, pod, egressAnnotationOptOuts(pod, defaultAnnotations)); err != nil {
+				return err
+			}
 		}
 
-		// unmarshal the data as yaml
-		defaultAnnotations := make(map[string]string)
-		if err := yaml.Unmarshal([]byte(configMap.Data["annotations.yaml"]), &defaultAnnotations); err != nil {
-			return fmt.Errorf("marshaling the configmap data as yaml: %w", err)
+		// the pod may only set the annotations administrators allow it to override
+		if errs := c.Overrides.Check("Pod", pod.Annotations, defaultAnnotations); len(errs) > 0 {
+			return apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, podName(pod), errs)
 		}
 
 		if pod.Annotations == nil {
@@ -116,14 +166,143 @@ func (c *Config) Init(pod *corev1.Pod) error {
 		c.annotations = pod.Annotations
 	}
 
+	// parse the annotations into settings, reporting every invalid annotation at once
+	settings, errs := ParseSettings(c.annotations)
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, podName(pod), errs)
+	}
+	c.Settings = settings
+
+	// the egress of excluded containers is accepted by their UID, which no routed container may share
+	if c.EgressType == EgressType_SERVICE || c.EgressType == EgressType_INJECT {
+		if errs := excludedUidErrors(pod, settings); len(errs) > 0 {
+			return apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, podName(pod), errs)
+		}
+	}
+
+	// the proxy environment variables are computed from the listen and port mapping settings
+	if c.EgressType == EgressType_PROXY_ENV {
+		env, errs := proxyEnv(settings)
+		if len(errs) > 0 {
+			return apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, podName(pod), errs)
+		}
+		c.proxyEnv = env
+
+		// the variables the containers set themselves take precedence
+		if namespaceEgressType != EgressType_UNDEFINED {
+			if err := c.checkEgressOptOut(namespace, pod, proxyEnvOptOuts(pod, settings.ExcludeContainers, env)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the pod security standard of the namespace has to allow the containers that are injected
+	if err := c.checkPodSecurity(namespace, pod); err != nil {
+		return err
+	}
+
+	// the defaults of the namespace for the resources the annotations don't set
+	resourceDefaults, resourceMax, err := c.limitRangeDefaults()
+	if err != nil {
+		return err
+	}
+	c.resourceDefaults = resourceDefaults
+	c.resourceMax = resourceMax
+
 	// determine if we should inject the certificate authority
-	if c.GetAnnotation("inject-ca") == "true" {
-		c.InjectCa = true
+	c.InjectCa = settings.InjectCa
+
+	// the pod may not bring its own containers named like the ones the operator injects
+	if errs := reservedContainerErrors(pod, c); len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, podName(pod), errs)
+	}
+
+	// nor request more than the limit ranges of the namespace allow for the injected containers
+	injected := c.injectedContainers()
+	errs = field.ErrorList{}
+	if _, exists := injected["qtap-init"]; exists {
+		errs = append(errs, c.resourceMaxErrors("qtap-init", settings.Init.Resources)...)
+	}
+	if _, exists := injected["qtap"]; exists {
+		errs = append(errs, c.resourceMaxErrors("qtap", settings.Qtap.Resources)...)
+	}
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, podName(pod), errs)
 	}
 
 	return nil
 }
 
-func (c *Config) GetAnnotation(key string) string {
-	return c.annotations[fmt.Sprintf("qpoint.io/%s", key)]
+// podName is the name of the pod, or its generate name as pods created by controllers are named by the api
+func podName(pod *corev1.Pod) string {
+	if pod.Name == "" {
+		return pod.GenerateName
+	}
+	return pod.Name
+}
+
+// defaultAnnotations resolves the default annotations for the egress type of the config. A QtapEgressPolicy in
+// the pod namespace takes precedence over a ClusterQtapEgressPolicy, and the annotations configmap is only used
+// as a fallback when neither exists (or the policy CRDs have not been installed yet).
+func (c *Config) defaultAnnotations(configMapName string) (map[string]string, error) {
+	policy, err := c.resolvePolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	if policy != nil {
+		c.policy = policy
+		return policy.Annotations(), nil
+	}
+
+	// let's fetch the default settings in the configmap
+	configMap := &corev1.ConfigMap{}
+	if err := c.Client.Get(c.Ctx, client.ObjectKey{Name: configMapName, Namespace: c.OperatorNamespace}, configMap); err != nil {
+		return nil, fmt.Errorf("fetching configmap '%s' at namespace '%s' from the api: %w", configMapName, c.OperatorNamespace, err)
+	}
+
+	// unmarshal the data as yaml
+	defaultAnnotations := make(map[string]string)
+	if err := yaml.Unmarshal([]byte(configMap.Data["annotations.yaml"]), &defaultAnnotations); err != nil {
+		return nil, fmt.Errorf("marshaling the configmap data as yaml: %w", err)
+	}
+
+	return defaultAnnotations, nil
+}
+
+// resolvePolicy finds the policy for the egress type of the config. When several policies exist for the same
+// mode the first one by name is used so that the result is deterministic.
+func (c *Config) resolvePolicy() (*QtapEgressPolicySpec, error) {
+	policies := &QtapEgressPolicyList{}
+	if err := c.Client.List(c.Ctx, policies, client.InNamespace(c.Namespace)); err != nil {
+		if meta.IsNoMatchError(err) {
+			// the CRDs are not installed, fallback to the configmaps
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing egress policies at namespace '%s' from the api: %w", c.Namespace, err)
+	}
+
+	sort.Slice(policies.Items, func(i, j int) bool { return policies.Items[i].Name < policies.Items[j].Name })
+	for i := range policies.Items {
+		if policies.Items[i].Spec.Mode == c.EgressType {
+			return &policies.Items[i].Spec, nil
+		}
+	}
+
+	clusterPolicies := &ClusterQtapEgressPolicyList{}
+	if err := c.Client.List(c.Ctx, clusterPolicies); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing cluster egress policies from the api: %w", err)
+	}
+
+	sort.Slice(clusterPolicies.Items, func(i, j int) bool { return clusterPolicies.Items[i].Name < clusterPolicies.Items[j].Name })
+	for i := range clusterPolicies.Items {
+		if clusterPolicies.Items[i].Spec.Mode == c.EgressType {
+			return &clusterPolicies.Items[i].Spec, nil
+		}
+	}
+
+	return nil, nil
 }
@@ -2,114 +2,68 @@ package v1
 
 import (
 	"fmt"
-	"math"
-	"net"
-	"regexp"
 	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	"sigs.k8s.io/controller-runtime/pkg/client"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 const INIT_IMAGE = "us-docker.pkg.dev/qpoint-edge/public/kubernetes-qtap-init"
 const QTAP_IMAGE = "us-docker.pkg.dev/qpoint-edge/public/qtap"
 
 func MutateEgress(pod *corev1.Pod, config *Config) error {
-	// fetch the init image tag
-	tag := config.GetAnnotation("qtap-init-tag")
+	settings := config.Settings.Init
 
 	// create an init container
 	initContainer := corev1.Container{
-		Name:  "qtap-init",
-		Image: fmt.Sprintf("%s:%s", INIT_IMAGE, tag),
-		Env:   []corev1.EnvVar{},
+		Name:            "qtap-init",
+		Image:           config.initImage(),
+		ImagePullPolicy: config.Settings.ImagePullPolicy,
+		Env:             []corev1.EnvVar{},
 		SecurityContext: &corev1.SecurityContext{
+			// only the capabilities needed to manage iptables are added, everything else is dropped
 			Capabilities: &corev1.Capabilities{
-				Add: []corev1.Capability{"NET_ADMIN"},
+				Add:  settings.Capabilities,
+				Drop: []corev1.Capability{"ALL"},
 			},
 			// The init container needs to run as root as it modifies the network
 			// for the pod. Sometimes it also requires privileged depending on the
-			// security within the cluster. See annotations below which allow for
-			// setting the running user and group and other settings.
+			// security within the cluster. The qtap-init-run-as-* annotations allow
+			// for setting the running user and group and other settings.
+			RunAsUser:                settings.RunAsUser,
+			RunAsGroup:               settings.RunAsGroup,
+			RunAsNonRoot:             settings.RunAsNonRoot,
+			Privileged:               settings.Privileged,
+			AllowPrivilegeEscalation: settings.AllowPrivilegeEscalation,
+			ReadOnlyRootFilesystem:   settings.ReadOnlyRootFilesystem,
+			SeccompProfile:           seccompProfile(settings.SeccompProfile),
 		},
+		Resources: config.containerResources("qtap-init", settings.Resources),
 	}
 
-	// SecurityContext RunAsUser
-	if runAsUser := config.GetAnnotation("qtap-init-run-as-user"); runAsUser != "" {
-		i, err := strconv.ParseInt(runAsUser, 10, 64)
-		if err != nil {
-			return fmt.Errorf("conversion error: %w", err)
-		}
-		initContainer.SecurityContext.RunAsUser = &i
+	// a privileged container always allows privilege escalation, which the api rejects being set to false
+	if settings.Privileged != nil && *settings.Privileged {
+		initContainer.SecurityContext.AllowPrivilegeEscalation = nil
 	}
 
-	// SecurityContext RunAsGroup
-	if runAsGroup := config.GetAnnotation("qtap-init-run-as-group"); runAsGroup != "" {
-		i, err := strconv.ParseInt(runAsGroup, 10, 64)
-		if err != nil {
-			return fmt.Errorf("conversion error: %w", err)
-		}
-		initContainer.SecurityContext.RunAsGroup = &i
-	}
+	// TO_ADDR, TO_DOMAIN, PORT_MAPPING, ACCEPT_UIDS, ACCEPT_GIDS
+	initContainer.Env = append(initContainer.Env, settings.Env...)
 
-	// SecurityContext RunAsNonRoot
-	if runAsNonRoot := config.GetAnnotation("qtap-init-run-as-non-root"); runAsNonRoot != "" {
-		b, err := strconv.ParseBool(runAsNonRoot)
-		if err != nil {
-			return fmt.Errorf("conversion error: %w", err)
+	// the egress of excluded containers is accepted by the UID they run as, which excludedUidErrors made sure
+	// no other container runs as
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name == "qtap" || !contains(config.Settings.ExcludeContainers, container.Name) {
+			continue
 		}
-		initContainer.SecurityContext.RunAsNonRoot = &b
-	}
-
-	// SecurityContext Privileged
-	if privileged := config.GetAnnotation("qtap-init-run-as-privileged"); privileged != "" {
-		b, err := strconv.ParseBool(privileged)
-		if err != nil {
-			return fmt.Errorf("conversion error: %w", err)
+		uid := effectiveRunAsUser(pod, container)
+		if uid == nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("container '%s' is excluded but neither it nor the pod sets securityContext.runAsUser, its egress is still routed through qtap", container.Name))
+			continue
 		}
-		initContainer.SecurityContext.Privileged = &b
-	}
-
-	// TO_ADDR
-	if toAddr := config.GetAnnotation("qtap-init-egress-to-addr"); toAddr != "" {
-		initContainer.Env = append(initContainer.Env, corev1.EnvVar{
-			Name:  "TO_ADDR",
-			Value: toAddr,
-		})
-	}
-
-	// TO_DOMAIN
-	if toDomain := config.GetAnnotation("qtap-init-egress-to-domain"); toDomain != "" {
-		initContainer.Env = append(initContainer.Env, corev1.EnvVar{
-			Name:  "TO_DOMAIN",
-			Value: toDomain,
-		})
-	}
-
-	// PORT_MAPPING
-	if portMapping := config.GetAnnotation("qtap-init-egress-port-mapping"); portMapping != "" {
-		initContainer.Env = append(initContainer.Env, corev1.EnvVar{
-			Name:  "PORT_MAPPING",
-			Value: portMapping,
-		})
-	}
-
-	// ACCEPT_UIDS
-	if acceptUids := config.GetAnnotation("qtap-init-egress-accept-uids"); acceptUids != "" {
-		initContainer.Env = append(initContainer.Env, corev1.EnvVar{
-			Name:  "ACCEPT_UIDS",
-			Value: acceptUids,
-		})
-	}
-
-	// ACCEPT_GIDS
-	if acceptGids := config.GetAnnotation("qtap-init-egress-accept-gids"); acceptGids != "" {
-		initContainer.Env = append(initContainer.Env, corev1.EnvVar{
-			Name:  "ACCEPT_GIDS",
-			Value: acceptGids,
-		})
+		initContainer.Env = appendEnvValue(initContainer.Env, "ACCEPT_UIDS", strconv.FormatInt(*uid, 10), ",")
 	}
 
 	// ensure init containers has been initialized
@@ -117,91 +71,106 @@ func MutateEgress(pod *corev1.Pod, config *Config) error {
 		pod.Spec.InitContainers = make([]corev1.Container, 0)
 	}
 
-	// append to the list
-	pod.Spec.InitContainers = append([]corev1.Container{initContainer}, pod.Spec.InitContainers...)
+	// prepend to the list (or replace a qtap-init container that already exists)
+	pod.Spec.InitContainers = upsertContainer(pod.Spec.InitContainers, initContainer)
+
+	// the image may be in a private registry
+	addImagePullSecrets(pod, config)
 
 	// gtg
 	return nil
 }
 
-func MutateInjection(pod *corev1.Pod, config *Config) error {
-	// in order to start qtap a token is needed. This token can be found at a defined secret name token
-	secret := &corev1.Secret{}
-	if err := config.Client.Get(config.Ctx, client.ObjectKey{Name: "token", Namespace: config.OperatorNamespace}, secret); err != nil {
-		return fmt.Errorf("fetching secret '%s' at namespace '%s' from the api: %w", "token", config.OperatorNamespace, err)
+// effectiveRunAsUser is the UID the container runs as according to the pod spec, the container setting takes
+// precedence over the pod setting. It is nil when the UID is left to the image.
+func effectiveRunAsUser(pod *corev1.Pod, container *corev1.Container) *int64 {
+	if container.SecurityContext != nil && container.SecurityContext.RunAsUser != nil {
+		return container.SecurityContext.RunAsUser
 	}
-
-	tokenBytes, exists := secret.Data["token"]
-	if !exists {
-		return fmt.Errorf("token not found in secret '%s'", "token")
+	if pod.Spec.SecurityContext != nil {
+		return pod.Spec.SecurityContext.RunAsUser
 	}
+	return nil
+}
 
-	// convert the []byte data to a string
-	token := string(tokenBytes)
-
-	// fetch the init image tag
-	tag := config.GetAnnotation("qtap-tag")
-
-	// maintains the default of a nil security context (which is equivalent to accepting the pod setting)
-	var securityContext *corev1.SecurityContext = nil
-
-	// if the UID and/or GID annotations were set then try to convert them to the correct format for the security context
-	if uid, gid := config.GetAnnotation("qtap-uid"), config.GetAnnotation("qtap-gid"); uid != "" || gid != "" {
-		var qtapUid int64 = math.MinInt64 // this isn't a permitted UID value and so it is used as not set
-		var qtapGid int64 = math.MinInt64 // this isn't a permitted GID value and so it is used as not set
+// excludedUidErrors rejects excluded containers that run as the same UID as a container whose egress is routed
+// through qtap, as the egress is excluded by UID and the other container would bypass qtap as well
+func excludedUidErrors(pod *corev1.Pod, settings *Settings) field.ErrorList {
+	errs := field.ErrorList{}
+	path := field.NewPath("metadata", "annotations").Key(ANNOTATION_PREFIX + "exclude-containers")
 
-		if uid != "" {
-			if n, err := strconv.ParseInt(uid, 10, 64); err == nil {
-				qtapUid = n
-			}
+	for i := range pod.Spec.Containers {
+		excluded := &pod.Spec.Containers[i]
+		if excluded.Name == "qtap" || !contains(settings.ExcludeContainers, excluded.Name) {
+			continue
 		}
-		if gid != "" {
-			if n, err := strconv.ParseInt(gid, 10, 64); err == nil {
-				qtapGid = n
-			}
+		uid := effectiveRunAsUser(pod, excluded)
+		if uid == nil {
+			continue
 		}
 
-		// If a UID was set via annotations we need a security context for the container with the UID
-		// and/or GID
-		if qtapUid != math.MinInt64 || qtapGid != math.MinInt64 {
-			securityContext = &corev1.SecurityContext{} // create empty security context
-
-			// the UID was set, set RunAsUser
-			if qtapUid != math.MinInt64 {
-				securityContext.RunAsUser = &qtapUid
+		for j := range pod.Spec.Containers {
+			routed := &pod.Spec.Containers[j]
+			if routed.Name == "qtap" || contains(settings.ExcludeContainers, routed.Name) {
+				continue
 			}
-
-			// the GID was set, set RunAsGroup
-			if qtapGid != math.MinInt64 {
-				securityContext.RunAsGroup = &qtapGid
+			if other := effectiveRunAsUser(pod, routed); other != nil && *other == *uid {
+				errs = append(errs, field.Invalid(path, strings.Join(settings.ExcludeContainers, ","),
+					fmt.Sprintf("container '%s' runs as uid %d like container '%s', whose egress would no longer be routed through qtap either", excluded.Name, *uid, routed.Name)))
 			}
 		}
 	}
 
-	statusListen := config.GetAnnotation("qtap-status-listen")
-	var statusPort int32 = 10001
-	if statusListen != "" {
-		if _, port, err := net.SplitHostPort(statusListen); err == nil {
-			portInt, err := strconv.ParseInt(port, 0, 16)
-			if err != nil {
-				return fmt.Errorf("invalid port: %w", err)
-			}
-			statusPort = int32(portInt)
-		}
+	return errs
+}
+
+func MutateInjection(pod *corev1.Pod, config *Config) error {
+	settings := config.Settings.Qtap
+
+	// qtap needs no capabilities or writable root filesystem, which keeps it within the restricted pod
+	// security standard. The UID and GID fall back to the pod setting when not set via annotations.
+	securityContext := &corev1.SecurityContext{
+		RunAsUser:                settings.Uid,
+		RunAsGroup:               settings.Gid,
+		RunAsNonRoot:             settings.RunAsNonRoot,
+		AllowPrivilegeEscalation: settings.AllowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   settings.ReadOnlyRootFilesystem,
+		SeccompProfile:           seccompProfile(settings.SeccompProfile),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+
+	// a non-root UID satisfies runAsNonRoot, which the restricted standard requires
+	if securityContext.RunAsNonRoot == nil && settings.Uid != nil && *settings.Uid != 0 {
+		securityContext.RunAsNonRoot = ptr(true)
 	}
 
+	statusPort := settings.StatusPort
+
 	// create an qtap container
 	qtapContainer := corev1.Container{
-		Name:  "qtap",
-		Image: fmt.Sprintf("%s:%s", QTAP_IMAGE, tag),
-		Args:  []string{"gateway"},
+		Name:            "qtap",
+		Image:           config.qtapImage(),
+		ImagePullPolicy: config.Settings.ImagePullPolicy,
+		Args:            []string{"gateway"},
 		Env: []corev1.EnvVar{
+			// in order to start qtap a token is needed. The token is referenced from the copy of the selected
+			// token secret the namespace controller syncs into the namespace so it isn't readable from the pod spec
 			{
-				Name:  "TOKEN",
-				Value: token,
+				Name: "TOKEN",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: SyncedTokenSecret(settings.TokenSecret),
+						},
+						Key: TOKEN_KEY,
+					},
+				},
 			},
 		},
 		SecurityContext: securityContext,
+		Resources:       config.containerResources("qtap", settings.Resources),
 		StartupProbe: &corev1.Probe{
 			ProbeHandler: corev1.ProbeHandler{
 				HTTPGet: &corev1.HTTPGetAction{
@@ -249,115 +218,40 @@ func MutateInjection(pod *corev1.Pod, config *Config) error {
 		},
 	}
 
-	// LOG_LEVEL
-	if logLevel := config.GetAnnotation("qtap-log-level"); logLevel != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "LOG_LEVEL",
-			Value: logLevel,
-		})
-	}
-
-	// LOG_ENCODING
-	if logEncoding := config.GetAnnotation("qtap-log-encoding"); logEncoding != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "LOG_ENCODING",
-			Value: logEncoding,
-		})
-	}
-
-	// LOG_CALLER
-	if logCaller := config.GetAnnotation("qtap-log-caller"); logCaller != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "LOG_CALLER",
-			Value: logCaller,
-		})
-	}
-
-	// HTTP_LISTEN
-	if httpListen := config.GetAnnotation("qtap-egress-http-listen"); httpListen != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "EGRESS_HTTP_LISTEN",
-			Value: httpListen,
-		})
-	}
-
-	// HTTPS_LISTEN
-	if httpsListen := config.GetAnnotation("qtap-egress-https-listen"); httpsListen != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "EGRESS_HTTPS_LISTEN",
-			Value: httpsListen,
-		})
-	}
-
-	// STATUS_LISTEN
-	// The annotation was already read above as it is needed to determine the Kubernetes probe port
-	if statusListen != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "STATUS_LISTEN",
-			Value: statusListen,
-		})
-	}
-
-	// BLOCK_UNKNOWN
-	if blockUnknown := config.GetAnnotation("qtap-block-unknown"); blockUnknown != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "BLOCK_UNKNOWN",
-			Value: blockUnknown,
-		})
-	}
+	// the image may be in a private registry
+	addImagePullSecrets(pod, config)
 
-	// ENVOY_LOG_LEVEL
-	if envoyLogLevel := config.GetAnnotation("qtap-envoy-log-level"); envoyLogLevel != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "ENVOY_LOG_LEVEL",
-			Value: envoyLogLevel,
+	// with a read-only root filesystem qtap still gets a writable /tmp
+	if settings.ReadOnlyRootFilesystem != nil && *settings.ReadOnlyRootFilesystem {
+		pod.Spec.Volumes = upsertVolume(pod.Spec.Volumes, corev1.Volume{
+			Name: "qtap-tmp-volume",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
 		})
-	}
-
-	// DNS_LOOKUP_FAMILY
-	if dnsLookupFamily := config.GetAnnotation("qtap-dns-lookup-family"); dnsLookupFamily != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "DNS_LOOKUP_FAMILY",
-			Value: dnsLookupFamily,
+		qtapContainer.VolumeMounts = append(qtapContainer.VolumeMounts, corev1.VolumeMount{
+			Name:      "qtap-tmp-volume",
+			MountPath: "/tmp",
 		})
 	}
 
-	// API_ENDPOINT
-	if apiEndpoint := config.GetAnnotation("qtap-api-endpoint"); apiEndpoint != "" {
-		qtapContainer.Env = append(qtapContainer.Env, corev1.EnvVar{
-			Name:  "ENDPOINT",
-			Value: apiEndpoint,
-		})
-	}
+	// LOG_LEVEL, LOG_ENCODING, LOG_CALLER, EGRESS_HTTP_LISTEN, EGRESS_HTTPS_LISTEN, STATUS_LISTEN,
+	// BLOCK_UNKNOWN, ENVOY_LOG_LEVEL, DNS_LOOKUP_FAMILY, ENDPOINT
+	qtapContainer.Env = append(qtapContainer.Env, settings.Env...)
 
 	// by default the pods namespace is always added as a tag. This slice is used for appending
 	// additional tags below
 	tags := []string{strings.Join([]string{"namespace", pod.Namespace}, ":")}
 
-	//TAGS
-	if tagsFilters := config.GetAnnotation("qtap-labels-tags-filter"); tagsFilters != "" {
-		// the filter is a list of regular expressions used to determine if labels should be added
-		// as tags to qtap
-
-		regexps := []*regexp.Regexp{}
-
-		// loop over the comma separated list of regular expressions and compile a regular expression
-		// list that will be used to compare against the labels
-		for _, filter := range strings.Split(tagsFilters, ",") {
-			if regexFilter, err := regexp.Compile(filter); err != nil {
-				return fmt.Errorf("invalid regular expression for tags filter: %w", err)
-			} else {
-				regexps = append(regexps, regexFilter)
-			}
-		}
-
-		// loop over all pod labels and if key that matches a regular expression then append it to the
-		// list of tags
-		for k, v := range pod.Labels {
-			for _, r := range regexps {
-				if r.MatchString(k) {
-					tags = append(tags, strings.Join([]string{k, v}, ":"))
-				}
+	// TAGS
+	// the filter is a list of regular expressions used to determine if labels should be added
+	// as tags to qtap. Loop over all pod labels and if key that matches a regular expression then
+	// append it to the list of tags
+	// appending the tags in order keeps the container the same when the pod is mutated again
+	for _, k := range sortedKeys(pod.Labels) {
+		for _, r := range settings.TagsFilters {
+			if r.MatchString(k) {
+				tags = append(tags, strings.Join([]string{k, pod.Labels[k]}, ":"))
 			}
 		}
 	}
@@ -367,9 +261,34 @@ func MutateInjection(pod *corev1.Pod, config *Config) error {
 		Value: strings.Join(tags, ","),
 	})
 
-	// append to the list
-	pod.Spec.Containers = append([]corev1.Container{qtapContainer}, pod.Spec.Containers...)
+	if config.NativeSidecars {
+		// as a native sidecar qtap starts right after qtap-init and before the init containers of the application,
+		// whose egress is already routed through it, and doesn't keep the pod from completing
+		restartPolicy := corev1.ContainerRestartPolicyAlways
+		qtapContainer.RestartPolicy = &restartPolicy
+		pod.Spec.InitContainers = insertContainerAfter(pod.Spec.InitContainers, qtapContainer, "qtap-init")
+	} else {
+		// the job sidecar controller stops qtap once the application containers of a Job have completed
+		if IsJobPod(pod) {
+			addShutdownFile(pod, &qtapContainer)
+			if pod.Annotations == nil {
+				pod.Annotations = make(map[string]string)
+			}
+			pod.Annotations[JOB_SIDECAR_ANNOTATION] = "true"
+		}
+
+		// prepend to the list (or replace a qtap container that already exists)
+		pod.Spec.Containers = upsertContainer(pod.Spec.Containers, qtapContainer)
+	}
 
 	// gtg
 	return nil
 }
+
+// seccompProfile returns the seccomp profile of the type, or nil to inherit the pod setting
+func seccompProfile(profileType corev1.SeccompProfileType) *corev1.SeccompProfile {
+	if profileType == "" {
+		return nil
+	}
+	return &corev1.SeccompProfile{Type: profileType}
+}
@@ -0,0 +1,194 @@
+package v1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// NAMESPACE_EGRESS_ENFORCED_LABEL keeps the pods of a namespace from disabling egress with the qpoint.io/egress label
+const NAMESPACE_EGRESS_ENFORCED_LABEL = "qpoint.io/egress-enforced"
+
+// EGRESS_EXEMPTIONS_CONFIGMAP lists the pods in the operator namespace that may disable an enforced egress
+const EGRESS_EXEMPTIONS_CONFIGMAP = "qtap-operator-egress-exemptions-configmap"
+const EGRESS_EXEMPTIONS_KEY = "exemptions.yaml"
+
+// EgressExemption matches the pods that may disable egress when it is enforced. Every field that is set has to
+// match, and an exemption without a namespace applies to every namespace.
+type EgressExemption struct {
+	Namespace      string                `json:"namespace,omitempty"`
+	ServiceAccount string                `json:"serviceAccount,omitempty"`
+	PodSelector    *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
+
+// Matches determines if the exemption applies to the pod
+func (e *EgressExemption) Matches(pod *corev1.Pod, namespace string) (bool, error) {
+	if e.Namespace != "" && e.Namespace != namespace {
+		return false, nil
+	}
+
+	if e.ServiceAccount != "" {
+		serviceAccount := pod.Spec.ServiceAccountName
+		if serviceAccount == "" {
+			serviceAccount = "default"
+		}
+		if e.ServiceAccount != serviceAccount {
+			return false, nil
+		}
+	}
+
+	if e.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(e.PodSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid pod selector: %w", err)
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// the annotations that exempt the egress of some of the containers of a pod (or some of its destinations)
+// from qtap
+var egressOptOutAnnotations = []string{
+	ANNOTATION_PREFIX + "exclude-containers",
+	ANNOTATION_PREFIX + "qtap-init-egress-accept-uids",
+	ANNOTATION_PREFIX + "qtap-init-egress-accept-gids",
+	ANNOTATION_PREFIX + "qtap-init-egress-port-mapping",
+	ANNOTATION_PREFIX + "proxy-env-no-proxy",
+}
+
+// egressLabelOptOuts determines if the egress label of the pod disables egress, or switches to the proxy-env
+// mode which only applies to the clients honoring the proxy environment variables
+func egressLabelOptOuts(namespaceEgressType EgressType, pod *corev1.Pod) field.ErrorList {
+	path := field.NewPath("metadata", "labels").Key(POD_EGRESS_LABEL)
+
+	switch EgressType(pod.Labels[POD_EGRESS_LABEL]) {
+	case EgressType_DISABLE:
+		return field.ErrorList{field.Forbidden(path, "egress may not be disabled")}
+	case EgressType_PROXY_ENV:
+		if namespaceEgressType != EgressType_PROXY_ENV {
+			return field.ErrorList{field.Forbidden(path, "egress may not be switched to the proxy-env mode, which only applies to clients honoring the proxy environment variables")}
+		}
+	}
+
+	return nil
+}
+
+// egressAnnotationOptOuts lists the annotations of the pod that exempt containers from egress, unless they are
+// set to the defaults
+func egressAnnotationOptOuts(pod *corev1.Pod, defaults map[string]string) field.ErrorList {
+	errs := field.ErrorList{}
+	path := field.NewPath("metadata", "annotations")
+
+	for _, name := range egressOptOutAnnotations {
+		value := pod.Annotations[name]
+		if value == "" || value == defaults[name] {
+			continue
+		}
+		errs = append(errs, field.Forbidden(path.Key(name), "containers may not be exempted from egress"))
+	}
+
+	return errs
+}
+
+// proxyEnvOptOuts lists the proxy environment variables the containers of the pod set to other values than
+// the ones pointing at qtap, which the proxy-env mode leaves untouched
+func proxyEnvOptOuts(pod *corev1.Pod, excludeContainers []string, proxyEnv []corev1.EnvVar) field.ErrorList {
+	errs := field.ErrorList{}
+	path := field.NewPath("spec", "containers")
+
+	for i, container := range pod.Spec.Containers {
+		if container.Name == "qtap" || contains(excludeContainers, container.Name) {
+			continue
+		}
+		for j, env := range container.Env {
+			for _, proxy := range proxyEnv {
+				if env.Name == proxy.Name && (env.Value != proxy.Value || env.ValueFrom != nil) {
+					errs = append(errs, field.Forbidden(path.Index(i).Child("env").Index(j), fmt.Sprintf("%s may not be set to another value than the one pointing at qtap", env.Name)))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkEgressOptOut rejects a pod opting out of egress in a namespace where it is enforced, unless the pod is
+// exempt
+func (c *Config) checkEgressOptOut(namespace *corev1.Namespace, pod *corev1.Pod, optOuts field.ErrorList) error {
+	if len(optOuts) == 0 {
+		return nil
+	}
+
+	enforced, err := c.egressEnforced(namespace)
+	if err != nil || !enforced {
+		return err
+	}
+
+	exempt, err := c.egressExempt(pod)
+	if err != nil {
+		return err
+	}
+	if exempt {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("egress is enforced for namespace '%s', the pod is exempt and opts out: %s", c.Namespace, optOuts.ToAggregate().Error()))
+		return nil
+	}
+
+	for _, optOut := range optOuts {
+		optOut.Detail = fmt.Sprintf("egress is enforced for namespace '%s', %s, ask an administrator for an exemption", c.Namespace, optOut.Detail)
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, podName(pod), optOuts)
+}
+
+// egressEnforced determines if the egress of the namespace is enforced, either by the namespace label or by
+// the policy for the egress mode of the namespace
+func (c *Config) egressEnforced(namespace *corev1.Namespace) (bool, error) {
+	if namespace.Labels[NAMESPACE_EGRESS_ENFORCED_LABEL] == "true" {
+		return true, nil
+	}
+
+	policy, err := c.resolvePolicy()
+	if err != nil {
+		return false, err
+	}
+
+	return policy != nil && policy.Enforce, nil
+}
+
+// egressExempt determines if the pod matches one of the exemptions administrators manage in the operator namespace
+func (c *Config) egressExempt(pod *corev1.Pod) (bool, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := c.Client.Get(c.Ctx, client.ObjectKey{Name: EGRESS_EXEMPTIONS_CONFIGMAP, Namespace: c.OperatorNamespace}, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("fetching configmap '%s' at namespace '%s' from the api: %w", EGRESS_EXEMPTIONS_CONFIGMAP, c.OperatorNamespace, err)
+	}
+
+	exemptions := []EgressExemption{}
+	if err := yaml.Unmarshal([]byte(configMap.Data[EGRESS_EXEMPTIONS_KEY]), &exemptions); err != nil {
+		return false, fmt.Errorf("unmarshaling the egress exemptions as yaml: %w", err)
+	}
+
+	for i := range exemptions {
+		matches, err := exemptions[i].Matches(pod, c.Namespace)
+		if err != nil {
+			return false, fmt.Errorf("egress exemption %d: %w", i, err)
+		}
+		if matches {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
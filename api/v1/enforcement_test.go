@@ -0,0 +1,207 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestWebhookEgressOptOuts(t *testing.T) {
+	enforcedInject := map[string]string{NAMESPACE_EGRESS_LABEL: "inject", NAMESPACE_EGRESS_ENFORCED_LABEL: "true"}
+	enforcedProxyEnv := map[string]string{NAMESPACE_EGRESS_LABEL: "proxy-env", NAMESPACE_EGRESS_ENFORCED_LABEL: "true"}
+
+	withAnnotation := func(name string, value string) func() *corev1.Pod {
+		return func() *corev1.Pod {
+			return testPod(map[string]string{ANNOTATION_PREFIX + name: value})
+		}
+	}
+	withEgressLabel := func(egress EgressType) func() *corev1.Pod {
+		return func() *corev1.Pod {
+			pod := testPod(nil)
+			pod.Labels[POD_EGRESS_LABEL] = string(egress)
+			return pod
+		}
+	}
+	withEnv := func(env corev1.EnvVar) func() *corev1.Pod {
+		return func() *corev1.Pod {
+			pod := testPod(nil)
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{env}
+			return pod
+		}
+	}
+
+	tests := []struct {
+		name      string
+		namespace map[string]string
+		pod       func() *corev1.Pod
+		field     string
+	}{
+		{
+			name:      "the egress label disables egress",
+			namespace: enforcedInject,
+			pod:       withEgressLabel(EgressType_DISABLE),
+			field:     "metadata.labels[qpoint.io/egress]",
+		},
+		{
+			name:      "the egress label switches to the proxy-env mode",
+			namespace: enforcedInject,
+			pod:       withEgressLabel(EgressType_PROXY_ENV),
+			field:     "metadata.labels[qpoint.io/egress]",
+		},
+		{
+			name:      "exclude-containers",
+			namespace: enforcedInject,
+			pod:       withAnnotation("exclude-containers", "app"),
+			field:     "metadata.annotations[qpoint.io/exclude-containers]",
+		},
+		{
+			name:      "qtap-init-egress-accept-uids",
+			namespace: enforcedInject,
+			pod:       withAnnotation("qtap-init-egress-accept-uids", "1010,2000"),
+			field:     "metadata.annotations[qpoint.io/qtap-init-egress-accept-uids]",
+		},
+		{
+			name:      "qtap-init-egress-accept-gids",
+			namespace: enforcedInject,
+			pod:       withAnnotation("qtap-init-egress-accept-gids", "1010,2000"),
+			field:     "metadata.annotations[qpoint.io/qtap-init-egress-accept-gids]",
+		},
+		{
+			name:      "qtap-init-egress-port-mapping",
+			namespace: enforcedInject,
+			pod:       withAnnotation("qtap-init-egress-port-mapping", "10080:80"),
+			field:     "metadata.annotations[qpoint.io/qtap-init-egress-port-mapping]",
+		},
+		{
+			name:      "proxy-env-no-proxy",
+			namespace: enforcedProxyEnv,
+			pod:       withAnnotation("proxy-env-no-proxy", "*"),
+			field:     "metadata.annotations[qpoint.io/proxy-env-no-proxy]",
+		},
+		{
+			name:      "a container setting its own proxy",
+			namespace: enforcedProxyEnv,
+			pod:       withEnv(corev1.EnvVar{Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"}),
+			field:     "spec.containers[0].env[0]",
+		},
+		{
+			name:      "a container setting its own no_proxy",
+			namespace: enforcedProxyEnv,
+			pod:       withEnv(corev1.EnvVar{Name: "no_proxy", Value: "*"}),
+			field:     "spec.containers[0].env[0]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the override policy blocks most of these on its own, it allows everything to test the enforcement
+			overrides, err := NewOverridePolicy(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Run("without an exemption", func(t *testing.T) {
+				w := newTestWebhook(t, testNamespaceWith(tt.namespace))
+				w.Overrides = overrides
+
+				response := admitPod(t, w, tt.pod())
+				if response.Allowed {
+					t.Fatalf("the opt-out was allowed")
+				}
+				if message := response.Result.Message; !strings.Contains(message, tt.field) || !strings.Contains(message, "egress is enforced") {
+					t.Errorf("message %q doesn't name %s as enforced", message, tt.field)
+				}
+			})
+
+			t.Run("with an exemption", func(t *testing.T) {
+				w := newTestWebhook(t, testNamespaceWith(tt.namespace), testExemptions(t, EgressExemption{
+					Namespace:   testNamespace,
+					PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+				}))
+				w.Overrides = overrides
+
+				response := admitPod(t, w, tt.pod())
+				if !response.Allowed {
+					t.Fatalf("the exempt pod was denied: %v", response.Result.Message)
+				}
+				if len(response.Warnings) == 0 || !strings.Contains(strings.Join(response.Warnings, "\n"), "the pod is exempt") {
+					t.Errorf("warnings %v lack the exemption", response.Warnings)
+				}
+			})
+
+			t.Run("without enforcement", func(t *testing.T) {
+				namespace := map[string]string{NAMESPACE_EGRESS_LABEL: tt.namespace[NAMESPACE_EGRESS_LABEL]}
+				w := newTestWebhook(t, testNamespaceWith(namespace))
+				w.Overrides = overrides
+
+				if response := admitPod(t, w, tt.pod()); !response.Allowed {
+					t.Fatalf("the pod was denied: %v", response.Result.Message)
+				}
+			})
+		})
+	}
+}
+
+func TestWebhookEgressOptOutDefaults(t *testing.T) {
+	enforcedInject := map[string]string{NAMESPACE_EGRESS_LABEL: "inject", NAMESPACE_EGRESS_ENFORCED_LABEL: "true"}
+	enforcedProxyEnv := map[string]string{NAMESPACE_EGRESS_LABEL: "proxy-env", NAMESPACE_EGRESS_ENFORCED_LABEL: "true"}
+
+	tests := []struct {
+		name      string
+		namespace map[string]string
+		pod       func() *corev1.Pod
+	}{
+		{
+			name:      "the annotations set to the defaults",
+			namespace: enforcedInject,
+			pod: func() *corev1.Pod {
+				return testPod(map[string]string{
+					"qpoint.io/qtap-init-egress-port-mapping": testInjectAnnotations["qpoint.io/qtap-init-egress-port-mapping"],
+					"qpoint.io/qtap-init-egress-accept-uids":  testInjectAnnotations["qpoint.io/qtap-init-egress-accept-uids"],
+				})
+			},
+		},
+		{
+			name:      "the egress label of the namespace",
+			namespace: enforcedInject,
+			pod: func() *corev1.Pod {
+				pod := testPod(nil)
+				pod.Labels[POD_EGRESS_LABEL] = string(EgressType_INJECT)
+				return pod
+			},
+		},
+		{
+			name:      "a container setting the proxy the operator sets",
+			namespace: enforcedProxyEnv,
+			pod: func() *corev1.Pod {
+				pod := testPod(nil)
+				pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://127.0.0.1:10080"}}
+				return pod
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWebhook(t, testNamespaceWith(tt.namespace))
+			if response := admitPod(t, w, tt.pod()); !response.Allowed {
+				t.Fatalf("the pod was denied: %v", response.Result.Message)
+			}
+		})
+	}
+}
+
+func testExemptions(t *testing.T, exemptions ...EgressExemption) *corev1.ConfigMap {
+	t.Helper()
+	data, err := yaml.Marshal(exemptions)
+	if err != nil {
+		t.Fatalf("marshaling exemptions: %v", err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: EGRESS_EXEMPTIONS_CONFIGMAP, Namespace: testOperatorNamespace},
+		Data:       map[string]string{EGRESS_EXEMPTIONS_KEY: string(data)},
+	}
+}
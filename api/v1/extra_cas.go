@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EXTRA_CA_SOURCES_ANNOTATION lists the additional CAs of a namespace, such as the root of a TLS inspecting
+// corporate proxy. The sources are read from the namespace the annotation is on.
+const EXTRA_CA_SOURCES_ANNOTATION = "qpoint.io/extra-ca-sources"
+
+// the key read when a source doesn't specify one
+const DEFAULT_EXTRA_CA_KEY = "ca.crt"
+
+// ExtraCaSource references a configmap or secret key with PEM encoded CAs
+type ExtraCaSource struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Key       string
+}
+
+func (s ExtraCaSource) String() string {
+	return fmt.Sprintf("%s/%s/%s[%s]", strings.ToLower(s.Kind), s.Namespace, s.Name, s.Key)
+}
+
+// ParseExtraCaSources parses a comma separated list of sources in the format <configmap|secret>/<name>[/<key>]
+func ParseExtraCaSources(value string, namespace string) ([]ExtraCaSource, error) {
+	sources := []ExtraCaSource{}
+
+	for _, ref := range strings.Split(value, ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+
+		parts := strings.Split(ref, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[1] == "" {
+			return nil, fmt.Errorf("'%s' must be in the format <configmap|secret>/<name>[/<key>]", ref)
+		}
+
+		source := ExtraCaSource{Namespace: namespace, Name: parts[1], Key: DEFAULT_EXTRA_CA_KEY}
+		switch strings.ToLower(parts[0]) {
+		case "configmap":
+			source.Kind = "ConfigMap"
+		case "secret":
+			source.Kind = "Secret"
+		default:
+			return nil, fmt.Errorf("'%s' must reference a configmap or secret", ref)
+		}
+		if len(parts) == 3 && parts[2] != "" {
+			source.Key = parts[2]
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// LoadExtraCas reads and validates the CAs of each source. The CAs are de-duplicated by fingerprint and
+// expired CAs are dropped.
+func LoadExtraCas(ctx context.Context, c client.Reader, sources []ExtraCaSource) ([]TrustedCa, error) {
+	lists := [][]TrustedCa{}
+
+	for _, source := range sources {
+		var data string
+		switch source.Kind {
+		case "ConfigMap":
+			configMap := &corev1.ConfigMap{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: source.Namespace, Name: source.Name}, configMap); err != nil {
+				return nil, fmt.Errorf("retrieving extra CA source %s: %w", source, err)
+			}
+			data = configMap.Data[source.Key]
+		case "Secret":
+			secret := &corev1.Secret{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: source.Namespace, Name: source.Name}, secret); err != nil {
+				return nil, fmt.Errorf("retrieving extra CA source %s: %w", source, err)
+			}
+			data = string(secret.Data[source.Key])
+		}
+
+		if data == "" {
+			return nil, fmt.Errorf("extra CA source %s has no data", source)
+		}
+
+		cas, err := ParseTrustedCas(data)
+		if err != nil {
+			return nil, fmt.Errorf("extra CA source %s: %w", source, err)
+		}
+		lists = append(lists, cas)
+	}
+
+	now := time.Now()
+	valid := []TrustedCa{}
+	for _, ca := range MergeTrustedCas(lists...) {
+		if ca.NotAfter.After(now) {
+			valid = append(valid, ca)
+		}
+	}
+
+	return valid, nil
+}
+
+// ReferencesExtraCaSource determines if any of the sources is the given object
+func ReferencesExtraCaSource(sources []ExtraCaSource, kind string, namespace string, name string) bool {
+	for _, source := range sources {
+		if source.Kind == kind && source.Namespace == namespace && source.Name == name {
+			return true
+		}
+	}
+	return false
+}
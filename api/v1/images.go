@@ -0,0 +1,66 @@
+package v1
+
+import (
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PULL_SECRET_SOURCE_ANNOTATION marks the copy of an image pull secret synced into a namespace with the name of
+// the secret in the operator namespace it was copied from
+const PULL_SECRET_SOURCE_ANNOTATION = "qpoint.io/pull-secret-source"
+const QTAP_PULL_SECRET_PREFIX = "qtap-pull-"
+
+var imageRepositoryRegexp = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+var imageDigestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// SyncedPullSecret is the name of the copy of the image pull secret in the pod namespace
+func SyncedPullSecret(name string) string {
+	return QTAP_PULL_SECRET_PREFIX + name
+}
+
+// imageRef references the image by digest when one is pinned, and by tag otherwise
+func imageRef(repository string, tag string, digest string) string {
+	if digest != "" {
+		return repository + "@" + digest
+	}
+	return repository + ":" + tag
+}
+
+// initImage is the qtap-init image of the pod, from the repository of the annotations or the operator
+func (c *Config) initImage() string {
+	repository := c.Settings.Init.Repository
+	if repository == "" {
+		repository = c.InitRepository
+	}
+	if repository == "" {
+		repository = INIT_IMAGE
+	}
+	return imageRef(repository, c.Settings.Init.Tag, c.Settings.Init.Digest)
+}
+
+// qtapImage is the qtap image of the pod, from the repository of the annotations or the operator
+func (c *Config) qtapImage() string {
+	repository := c.Settings.Qtap.Repository
+	if repository == "" {
+		repository = c.QtapRepository
+	}
+	if repository == "" {
+		repository = QTAP_IMAGE
+	}
+	return imageRef(repository, c.Settings.Qtap.Tag, c.Settings.Qtap.Digest)
+}
+
+// addImagePullSecrets references the synced copies of the image pull secrets from the pod
+func addImagePullSecrets(pod *corev1.Pod, config *Config) {
+	for _, name := range config.ImagePullSecrets {
+		secret := corev1.LocalObjectReference{Name: SyncedPullSecret(name)}
+		exists := false
+		for _, existing := range pod.Spec.ImagePullSecrets {
+			exists = exists || existing.Name == secret.Name
+		}
+		if !exists {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, secret)
+		}
+	}
+}
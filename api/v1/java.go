@@ -0,0 +1,119 @@
+package v1
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const QTAP_JAVA_TRUSTSTORE = "qtap-java-truststore"                 // configmap with the java truststore
+const JAVA_TRUSTSTORE_KEY = "truststore.p12"                        // the truststore within the configmap
+const JAVA_TRUSTSTORE_DIR = "/var/run/qpoint/java"                  // where the configmap is mounted
+const JAVA_TRUSTSTORE_HASH_ANNOTATION = "qpoint.io/truststore-hash" // hash of the certificates within the truststore
+
+// The truststore only contains public certificates, the password is only there because older JVMs are unable
+// to read a truststore without one
+const JAVA_TRUSTSTORE_PASSWORD = pkcs12.DefaultPassword
+
+// the base bundle the java roots are taken from, the cacerts of the JDK packages are generated from the same
+// Mozilla roots
+const JAVA_BASE_BUNDLE = "ubuntu-ca-certificates.crt"
+
+// JavaTrustStoreCerts collects the certificates of the java truststore (the java roots, the extra CAs and the
+// Qpoint CAs) de-duplicated by fingerprint. A hash of the certificates is returned as well, which is used to
+// determine if the truststore needs to be encoded again as the encoding itself is salted.
+func JavaTrustStoreCerts(baseBundles []BaseBundle, qpointCas []TrustedCa, extraCas []TrustedCa) ([]pkcs12.TrustStoreEntry, string) {
+	pems := []string{}
+	for _, base := range baseBundles {
+		if base.Key == JAVA_BASE_BUNDLE {
+			pems = append(pems, base.Data)
+		}
+	}
+	pems = append(pems, JoinTrustedCas(MergeTrustedCas(extraCas, qpointCas)))
+
+	entries := []pkcs12.TrustStoreEntry{}
+	seen := map[string]bool{}
+	hash := sha256.New()
+
+	rest := []byte(strings.Join(pems, "\n"))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		sum := sha256.Sum256(block.Bytes)
+		fingerprint := hex.EncodeToString(sum[:])
+		if seen[fingerprint] {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			// the base bundles can contain certificates that are not supported, java would skip them as well
+			continue
+		}
+
+		seen[fingerprint] = true
+		hash.Write(sum[:])
+
+		// the fingerprint is used as the alias as the subjects of the roots aren't unique
+		entries = append(entries, pkcs12.TrustStoreEntry{Cert: cert, FriendlyName: fingerprint})
+	}
+
+	return entries, hex.EncodeToString(hash.Sum(nil))
+}
+
+// EncodeJavaTrustStore encodes the certificates as a PKCS12 truststore. The legacy encoding is used as it can
+// be read by every JVM since Java 8.
+func EncodeJavaTrustStore(entries []pkcs12.TrustStoreEntry) ([]byte, error) {
+	data, err := pkcs12.LegacyDES.EncodeTrustStoreEntries(entries, JAVA_TRUSTSTORE_PASSWORD)
+	if err != nil {
+		return nil, fmt.Errorf("encoding java truststore: %w", err)
+	}
+	return data, nil
+}
+
+// MutateJavaTrustStore mounts the java truststore of the namespace into the application containers and points
+// the JVM at it with JAVA_TOOL_OPTIONS
+func MutateJavaTrustStore(pod *corev1.Pod, config *Config) error {
+	pod.Spec.Volumes = upsertVolume(pod.Spec.Volumes, corev1.Volume{
+		Name: "qtap-java-truststore-volume",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: QTAP_JAVA_TRUSTSTORE,
+				},
+			},
+		},
+	})
+
+	// the directory is mounted rather than the file so that the kubelet keeps the truststore up to date
+	volumeMount := corev1.VolumeMount{
+		Name:      "qtap-java-truststore-volume",
+		MountPath: JAVA_TRUSTSTORE_DIR,
+		ReadOnly:  true,
+	}
+
+	options := fmt.Sprintf("-Djavax.net.ssl.trustStore=%s/%s -Djavax.net.ssl.trustStoreType=PKCS12 -Djavax.net.ssl.trustStorePassword=%s",
+		JAVA_TRUSTSTORE_DIR, JAVA_TRUSTSTORE_KEY, JAVA_TRUSTSTORE_PASSWORD)
+
+	for _, container := range caContainers(pod, config.Settings) {
+		if err := addVolumeMount(container, volumeMount, config.Settings.CaConflictPolicy); err != nil {
+			return err
+		}
+		container.Env = appendEnvValue(container.Env, "JAVA_TOOL_OPTIONS", options, " ")
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultDeniedOverrides are the annotations pods and namespaces may not override unless an administrator
+// allows them. These either select or send the token elsewhere, run another image with it, change the
+// privileges of the injected containers or exempt the egress of the pod from qtap.
+var DefaultDeniedOverrides = []string{
+	"token-secret",
+	"exclude-containers",
+	"qtap-api-endpoint",
+	"qtap-repository",
+	"qtap-init-repository",
+	"qtap-init-egress-to-addr",
+	"qtap-init-egress-to-domain",
+	"qtap-init-run-as-user",
+	"qtap-init-run-as-group",
+	"qtap-init-run-as-non-root",
+	"qtap-init-run-as-privileged",
+	"qtap-init-capabilities",
+	"qtap-init-allow-privilege-escalation",
+	"qtap-init-seccomp-profile",
+	"qtap-init-egress-accept-uids",
+	"qtap-init-egress-accept-gids",
+	"qtap-init-egress-port-mapping",
+	"proxy-env-no-proxy",
+	"qtap-seccomp-profile",
+}
+
+var blockedOverrides = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "qtap_operator_blocked_overrides_total",
+	Help: "Number of qpoint.io annotations pods and namespaces were blocked from overriding",
+}, []string{"kind", "annotation"})
+
+func init() {
+	metrics.Registry.MustRegister(blockedOverrides)
+}
+
+// OverridePolicy determines which qpoint.io annotations pods and namespaces may set themselves. When the allow
+// list is set only those annotations may be overridden, and the annotations in the deny list may never be.
+// Everything else comes from the policies or the annotation configmaps managed by the administrators.
+type OverridePolicy struct {
+	Allow map[string]bool
+	Deny  map[string]bool
+}
+
+// NewOverridePolicy creates a policy from lists of annotation names, with or without the qpoint.io/ prefix
+func NewOverridePolicy(allow []string, deny []string) (*OverridePolicy, error) {
+	toSet := func(keys []string) (map[string]bool, error) {
+		set := map[string]bool{}
+		for _, key := range keys {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+			if !strings.HasPrefix(key, ANNOTATION_PREFIX) {
+				key = ANNOTATION_PREFIX + key
+			}
+			if LookupAnnotation(key) == nil {
+				return nil, fmt.Errorf("'%s' is not a known qpoint annotation", key)
+			}
+			set[key] = true
+		}
+		return set, nil
+	}
+
+	allowed, err := toSet(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed overrides: %w", err)
+	}
+	denied, err := toSet(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied overrides: %w", err)
+	}
+
+	return &OverridePolicy{Allow: allowed, Deny: denied}, nil
+}
+
+// Blocked determines if the annotation may not be overridden
+func (p *OverridePolicy) Blocked(name string) bool {
+	if p == nil {
+		return false
+	}
+	if len(p.Allow) > 0 && !p.Allow[name] {
+		return true
+	}
+	return p.Deny[name]
+}
+
+// Check returns an error for every blocked annotation the object sets. Setting an annotation to the value of
+// the default is not an override, which allows copying the spec of a pod that was already mutated.
+func (p *OverridePolicy) Check(kind string, annotations map[string]string, defaults map[string]string) field.ErrorList {
+	errs := field.ErrorList{}
+	if p == nil {
+		return errs
+	}
+
+	path := field.NewPath("metadata", "annotations")
+
+	for _, name := range sortedKeys(annotations) {
+		annotation := LookupAnnotation(name)
+		if annotation == nil || !p.Blocked(name) {
+			continue
+		}
+		value, exists := defaults[name]
+		if !exists {
+			value, exists = annotation.Default, annotation.Default != ""
+		}
+		if exists && value == annotations[name] {
+			continue
+		}
+
+		blockedOverrides.WithLabelValues(kind, name).Inc()
+		errs = append(errs, field.Forbidden(path.Key(name), fmt.Sprintf("%ss may not override %s, it is managed by the cluster administrator", strings.ToLower(kind), name)))
+	}
+
+	return errs
+}
@@ -0,0 +1,182 @@
+package v1
+
+import (
+	"testing"
+)
+
+func TestNewOverridePolicy(t *testing.T) {
+	policy, err := NewOverridePolicy([]string{" token-secret", "qpoint.io/qtap-log-level", ""}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !policy.Allow["qpoint.io/token-secret"] || !policy.Allow["qpoint.io/qtap-log-level"] || len(policy.Allow) != 2 {
+		t.Errorf("allowed %v, want both annotations with the prefix", policy.Allow)
+	}
+
+	if _, err := NewOverridePolicy([]string{"qtap-log-level"}, []string{"not-an-annotation"}); err == nil {
+		t.Errorf("an unknown denied annotation was accepted")
+	}
+	if _, err := NewOverridePolicy([]string{"qpoint.io/unknown"}, nil); err == nil {
+		t.Errorf("an unknown allowed annotation was accepted")
+	}
+}
+
+func TestOverridePolicyBlocked(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		blocked map[string]bool
+	}{
+		{
+			name: "nothing is blocked without lists",
+			blocked: map[string]bool{
+				"qpoint.io/token-secret":   false,
+				"qpoint.io/qtap-log-level": false,
+			},
+		},
+		{
+			name: "the deny list blocks its annotations only",
+			deny: []string{"token-secret"},
+			blocked: map[string]bool{
+				"qpoint.io/token-secret":   true,
+				"qpoint.io/qtap-log-level": false,
+			},
+		},
+		{
+			name:  "the allow list blocks every other annotation",
+			allow: []string{"qtap-log-level"},
+			blocked: map[string]bool{
+				"qpoint.io/token-secret":   true,
+				"qpoint.io/qtap-log-level": false,
+				"qpoint.io/inject-ca":      true,
+			},
+		},
+		{
+			name:  "the deny list takes precedence over the allow list",
+			allow: []string{"qtap-log-level", "token-secret"},
+			deny:  []string{"token-secret"},
+			blocked: map[string]bool{
+				"qpoint.io/token-secret":   true,
+				"qpoint.io/qtap-log-level": false,
+			},
+		},
+		{
+			name: "the default deny list blocks the egress opt-outs",
+			deny: DefaultDeniedOverrides,
+			blocked: map[string]bool{
+				"qpoint.io/exclude-containers":            true,
+				"qpoint.io/qtap-init-egress-accept-uids":  true,
+				"qpoint.io/qtap-init-egress-accept-gids":  true,
+				"qpoint.io/qtap-init-egress-port-mapping": true,
+				"qpoint.io/proxy-env-no-proxy":            true,
+				"qpoint.io/qtap-log-level":                false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewOverridePolicy(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.blocked {
+				if got := policy.Blocked(name); got != want {
+					t.Errorf("%s blocked %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+
+	var policy *OverridePolicy
+	if policy.Blocked("qpoint.io/token-secret") {
+		t.Errorf("a nil policy blocked an annotation")
+	}
+}
+
+func TestOverridePolicyCheck(t *testing.T) {
+	policy, err := NewOverridePolicy(nil, DefaultDeniedOverrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		defaults    map[string]string
+		blocked     []string
+	}{
+		{
+			name:        "annotations that may be overridden",
+			annotations: map[string]string{"qpoint.io/qtap-log-level": "debug", "qpoint.io/inject-ca": "true"},
+		},
+		{
+			name:        "unknown annotations are left to the validation",
+			annotations: map[string]string{"qpoint.io/unknown": "value"},
+		},
+		{
+			name:        "a blocked annotation",
+			annotations: map[string]string{"qpoint.io/token-secret": "team-a-token"},
+			blocked:     []string{"qpoint.io/token-secret"},
+		},
+		{
+			name:        "a blocked annotation set to the default of the annotation configmap",
+			annotations: map[string]string{"qpoint.io/qtap-init-egress-port-mapping": "10080:80,10443:443"},
+			defaults:    map[string]string{"qpoint.io/qtap-init-egress-port-mapping": "10080:80,10443:443"},
+		},
+		{
+			name:        "a blocked annotation set to another value than the default of the annotation configmap",
+			annotations: map[string]string{"qpoint.io/qtap-init-egress-port-mapping": "10080:80"},
+			defaults:    map[string]string{"qpoint.io/qtap-init-egress-port-mapping": "10080:80,10443:443"},
+			blocked:     []string{"qpoint.io/qtap-init-egress-port-mapping"},
+		},
+		{
+			name:        "a blocked annotation set to the default of the registry",
+			annotations: map[string]string{"qpoint.io/qtap-init-capabilities": "NET_ADMIN,NET_RAW"},
+		},
+		{
+			name:        "the default of the annotation configmap takes precedence over the registry",
+			annotations: map[string]string{"qpoint.io/qtap-init-capabilities": "NET_ADMIN,NET_RAW"},
+			defaults:    map[string]string{"qpoint.io/qtap-init-capabilities": "NET_ADMIN"},
+			blocked:     []string{"qpoint.io/qtap-init-capabilities"},
+		},
+		{
+			name:        "an empty value is an override of an annotation without a default",
+			annotations: map[string]string{"qpoint.io/proxy-env-no-proxy": ""},
+			blocked:     []string{"qpoint.io/proxy-env-no-proxy"},
+		},
+		{
+			name: "every blocked annotation is reported in order",
+			annotations: map[string]string{
+				"qpoint.io/token-secret":             "team-a-token",
+				"qpoint.io/exclude-containers":       "app",
+				"qpoint.io/qtap-log-level":           "debug",
+				"qpoint.io/proxy-env-no-proxy":       "*",
+				"qpoint.io/qtap-init-run-as-user":    "0",
+				"qpoint.io/qtap-init-egress-to-addr": "10.0.0.1",
+			},
+			blocked: []string{
+				"qpoint.io/exclude-containers",
+				"qpoint.io/proxy-env-no-proxy",
+				"qpoint.io/qtap-init-egress-to-addr",
+				"qpoint.io/qtap-init-run-as-user",
+				"qpoint.io/token-secret",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := policy.Check("Pod", tt.annotations, tt.defaults)
+			if len(errs) != len(tt.blocked) {
+				t.Fatalf("errors %v, want %v blocked", errs, tt.blocked)
+			}
+			for i, name := range tt.blocked {
+				if want := "metadata.annotations[" + name + "]"; errs[i].Field != want {
+					t.Errorf("error on %s, want %s", errs[i].Field, want)
+				}
+			}
+		})
+	}
+}
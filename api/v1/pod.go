@@ -0,0 +1,294 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// MUTATED_ANNOTATION marks a pod as mutated by the operator. The value is the egress type the pod was mutated for.
+const MUTATED_ANNOTATION = "qpoint.io/mutated"
+
+// IsMarkedMutated determines if the pod carries the marker annotation, which pods could set themselves
+func IsMarkedMutated(pod *corev1.Pod) bool {
+	_, marked := pod.Annotations[MUTATED_ANNOTATION]
+	return marked
+}
+
+// IsMutated determines if the pod was already mutated by the operator. The marker annotation alone isn't
+// trusted, what was injected for the egress type of the marker has to be in the pod as well.
+func IsMutated(pod *corev1.Pod) bool {
+	switch EgressType(pod.Annotations[MUTATED_ANNOTATION]) {
+	case EgressType_SERVICE:
+		return hasContainer(pod, "qtap-init")
+	case EgressType_INJECT:
+		return hasContainer(pod, "qtap-init") && hasContainer(pod, "qtap")
+	case EgressType_PROXY_ENV:
+		if HasSidecar(pod.Annotations) && !hasContainer(pod, "qtap") {
+			return false
+		}
+		// every container that isn't excluded points at qtap
+		excluded := splitList(pod.Annotations[ANNOTATION_PREFIX+"exclude-containers"])
+		for _, container := range pod.Spec.Containers {
+			if container.Name == "qtap" || contains(excluded, container.Name) {
+				continue
+			}
+			if !hasEnv(container.Env, "HTTP_PROXY") {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// the names of the containers the operator injects
+var reservedContainers = []string{"qtap-init", "qtap", "qtap-ca-init"}
+
+// injectedContainers are the containers the operator injects for the config, mapped to whether they are
+// injected as init containers
+func (c *Config) injectedContainers() map[string]bool {
+	injected := map[string]bool{}
+	if c.EgressType == EgressType_SERVICE || c.EgressType == EgressType_INJECT {
+		injected["qtap-init"] = true
+	}
+	if c.EgressType == EgressType_INJECT || (c.EgressType == EgressType_PROXY_ENV && c.Settings.ProxyEnvTarget == ProxyEnvTarget_SIDECAR) {
+		injected["qtap"] = c.NativeSidecars
+	}
+	if c.InjectCa && c.Settings.CaMode == CaMode_MERGE {
+		injected["qtap-ca-init"] = true
+	}
+	return injected
+}
+
+// reservedContainerErrors rejects the containers of the pod named like the containers the operator injects. The
+// ones the operator injects for the config are allowed, as they are replaced in place when the webhook is
+// invoked again for a pod it already mutated.
+func reservedContainerErrors(pod *corev1.Pod, config *Config) field.ErrorList {
+	errs := field.ErrorList{}
+	injected := config.injectedContainers()
+
+	check := func(path *field.Path, containers []corev1.Container, init bool) {
+		for i := range containers {
+			name := containers[i].Name
+			if !contains(reservedContainers, name) {
+				continue
+			}
+			if asInit, exists := injected[name]; exists && asInit == init {
+				continue
+			}
+			errs = append(errs, field.Forbidden(path.Index(i).Child("name"), fmt.Sprintf("the container name '%s' is reserved for the containers injected by the operator", name)))
+		}
+	}
+	check(field.NewPath("spec", "initContainers"), pod.Spec.InitContainers, true)
+	check(field.NewPath("spec", "containers"), pod.Spec.Containers, false)
+
+	return errs
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			if containers[i].Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasEnv(env []corev1.EnvVar, name string) bool {
+	for i := range env {
+		if env[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkMutated leaves the marker annotation on the pod so that the mutation is not repeated when the webhook
+// is invoked again for the same pod
+func MarkMutated(pod *corev1.Pod, egressType EgressType) {
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[MUTATED_ANNOTATION] = string(egressType)
+}
+
+// HasSidecar determines from the annotations of a mutated pod if the qtap sidecar was injected
+func HasSidecar(annotations map[string]string) bool {
+	switch EgressType(annotations[MUTATED_ANNOTATION]) {
+	case EgressType_INJECT:
+		return true
+	case EgressType_PROXY_ENV:
+		return ProxyEnvTarget(annotations[ANNOTATION_PREFIX+"proxy-env-target"]) != ProxyEnvTarget_SERVICE
+	}
+	return false
+}
+
+// upsertContainer replaces the container with the same name in place or, when there isn't one, prepends it
+// to the list
+func upsertContainer(containers []corev1.Container, container corev1.Container) []corev1.Container {
+	for i := range containers {
+		if containers[i].Name == container.Name {
+			containers[i] = container
+			return containers
+		}
+	}
+	return append([]corev1.Container{container}, containers...)
+}
+
+// insertContainerAfter replaces the container with the same name in place or, when there isn't one, inserts it
+// after the container named after (or prepends it when there is no such container)
+func insertContainerAfter(containers []corev1.Container, container corev1.Container, after string) []corev1.Container {
+	for i := range containers {
+		if containers[i].Name == container.Name {
+			containers[i] = container
+			return containers
+		}
+	}
+	for i := range containers {
+		if containers[i].Name == after {
+			inserted := append([]corev1.Container{}, containers[:i+1]...)
+			inserted = append(inserted, container)
+			return append(inserted, containers[i+1:]...)
+		}
+	}
+	return append([]corev1.Container{container}, containers...)
+}
+
+// upsertVolume replaces the volume with the same name in place or, when there isn't one, appends it to the list
+func upsertVolume(volumes []corev1.Volume, volume corev1.Volume) []corev1.Volume {
+	for i := range volumes {
+		if volumes[i].Name == volume.Name {
+			volumes[i] = volume
+			return volumes
+		}
+	}
+	return append(volumes, volume)
+}
+
+// ConflictPolicy determines what happens when a container already mounts something at a path the operator
+// mounts to
+type ConflictPolicy string
+
+const (
+	ConflictPolicy_SKIP    ConflictPolicy = "skip"
+	ConflictPolicy_REPLACE ConflictPolicy = "replace"
+	ConflictPolicy_FAIL    ConflictPolicy = "fail"
+)
+
+// appendEnvValue appends the value to an environment variable the container already sets (separated by sep)
+// or, when there isn't one, adds the variable. Variables set from a source are left as is as the value
+// can't be appended to.
+func appendEnvValue(env []corev1.EnvVar, name string, value string, sep string) []corev1.EnvVar {
+	for i := range env {
+		if env[i].Name != name {
+			continue
+		}
+		if env[i].ValueFrom != nil || strings.Contains(sep+env[i].Value+sep, sep+value+sep) {
+			return env
+		}
+		if env[i].Value == "" {
+			env[i].Value = value
+		} else {
+			env[i].Value = env[i].Value + sep + value
+		}
+		return env
+	}
+	return append(env, corev1.EnvVar{Name: name, Value: value})
+}
+
+// setEnvDefault adds the environment variable unless the container already sets it
+func setEnvDefault(env []corev1.EnvVar, name string, value string) []corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == name {
+			return env
+		}
+	}
+	return append(env, corev1.EnvVar{Name: name, Value: value})
+}
+
+// MountConflictError is returned when a container already mounts a different volume at a path the operator
+// mounts to
+type MountConflictError struct {
+	Container string
+	Path      string
+	Volume    string
+}
+
+func (e *MountConflictError) Error() string {
+	return fmt.Sprintf("container '%s' already mounts volume '%s' at '%s', set qpoint.io/inject-ca-conflict-policy to skip or replace the mount",
+		e.Container, e.Volume, e.Path)
+}
+
+// addVolumeMount adds the mount to the container. When the container already mounts a different volume at the
+// same path the mount is skipped, replaces the existing mount or fails according to the conflict policy.
+func addVolumeMount(container *corev1.Container, mount corev1.VolumeMount, policy ConflictPolicy) error {
+	for i := range container.VolumeMounts {
+		existing := container.VolumeMounts[i]
+		if existing.MountPath != mount.MountPath {
+			continue
+		}
+
+		// a mount added by the operator
+		if existing.Name == mount.Name {
+			container.VolumeMounts[i] = mount
+			return nil
+		}
+
+		switch policy {
+		case ConflictPolicy_REPLACE:
+			container.VolumeMounts[i] = mount
+			return nil
+		case ConflictPolicy_FAIL:
+			return &MountConflictError{Container: container.Name, Path: mount.MountPath, Volume: existing.Name}
+		default:
+			return nil
+		}
+	}
+
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+	return nil
+}
+
+// caContainers selects the containers and init containers of the pod that receive the CA
+func caContainers(pod *corev1.Pod, settings *Settings) []*corev1.Container {
+	selected := []*corev1.Container{}
+
+	isSelected := func(name string) bool {
+		return !contains(settings.ExcludeContainers, name) && (len(settings.CaContainers) == 0 || contains(settings.CaContainers, name))
+	}
+
+	for i := range pod.Spec.Containers {
+		if isSelected(pod.Spec.Containers[i].Name) {
+			selected = append(selected, &pod.Spec.Containers[i])
+		}
+	}
+
+	for i := range pod.Spec.InitContainers {
+		name := pod.Spec.InitContainers[i].Name
+		// a native qtap sidecar is selected the same as the qtap container
+		if name == "qtap" && isSelected(name) {
+			selected = append(selected, &pod.Spec.InitContainers[i])
+			continue
+		}
+		if contains(settings.ExcludeContainers, name) || !contains(settings.CaInitContainers, name) {
+			continue
+		}
+		selected = append(selected, &pod.Spec.InitContainers[i])
+	}
+
+	return selected
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// the labels of the Pod Security Admission controller
+const POD_SECURITY_ENFORCE_LABEL = "pod-security.kubernetes.io/enforce"
+const POD_SECURITY_WARN_LABEL = "pod-security.kubernetes.io/warn"
+
+const (
+	PodSecurityLevel_PRIVILEGED = "privileged"
+	PodSecurityLevel_BASELINE   = "baseline"
+	PodSecurityLevel_RESTRICTED = "restricted"
+)
+
+// the capabilities the baseline standard allows containers to add, the restricted standard only allows
+// NET_BIND_SERVICE
+var baselineCapabilities = []string{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE",
+	"SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+var restrictedCapabilities = []string{"NET_BIND_SERVICE"}
+
+// PodSecurityError is returned when the containers the operator injects would be rejected by the Pod Security
+// Standard the namespace enforces
+type PodSecurityError struct {
+	Namespace  string
+	Level      string
+	Violations []string
+}
+
+func (e *PodSecurityError) Error() string {
+	return fmt.Sprintf("namespace '%s' enforces the '%s' pod security standard, which the containers injected for qpoint egress violate: %s. "+
+		"Label the namespace with %s=privileged, relax the qtap-init settings of the egress policy, use the qpoint.io/egress=proxy-env mode which needs no qtap-init container, "+
+		"or disable egress for the pod with the qpoint.io/egress=disable label",
+		e.Namespace, e.Level, strings.Join(e.Violations, "; "), POD_SECURITY_ENFORCE_LABEL)
+}
+
+// checkPodSecurity rejects the pod early with an explanation when the namespace enforces a Pod Security Standard
+// the injected containers violate, instead of the generic rejection of the admission controller. Violations of
+// the standard the namespace warns about are returned as warnings.
+func (c *Config) checkPodSecurity(namespace *corev1.Namespace, pod *corev1.Pod) error {
+	if level := namespace.Labels[POD_SECURITY_WARN_LABEL]; level != "" {
+		for _, violation := range podSecurityViolations(level, c.EgressType, c.Settings, pod) {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("would violate the '%s' pod security standard: %s", level, violation))
+		}
+	}
+
+	level := namespace.Labels[POD_SECURITY_ENFORCE_LABEL]
+	if violations := podSecurityViolations(level, c.EgressType, c.Settings, pod); len(violations) > 0 {
+		return &PodSecurityError{Namespace: namespace.Name, Level: level, Violations: violations}
+	}
+
+	return nil
+}
+
+// podSecurityViolations lists how the containers injected for the egress type violate the Pod Security Standard
+func podSecurityViolations(level string, egressType EgressType, settings *Settings, pod *corev1.Pod) []string {
+	if level != PodSecurityLevel_BASELINE && level != PodSecurityLevel_RESTRICTED {
+		return nil
+	}
+	restricted := level == PodSecurityLevel_RESTRICTED
+
+	podNonRoot := false
+	podSeccomp := corev1.SeccompProfileType("")
+	if sc := pod.Spec.SecurityContext; sc != nil {
+		podNonRoot = sc.RunAsNonRoot != nil && *sc.RunAsNonRoot
+		if sc.SeccompProfile != nil {
+			podSeccomp = sc.SeccompProfile.Type
+		}
+	}
+
+	violations := []string{}
+
+	check := func(container string, privileged *bool, capabilities []corev1.Capability, runAsUser *int64, runAsNonRoot *bool, allowPrivilegeEscalation *bool, seccomp corev1.SeccompProfileType) {
+		if privileged != nil && *privileged {
+			violations = append(violations, fmt.Sprintf("%s runs privileged", container))
+		}
+
+		allowed := baselineCapabilities
+		if restricted {
+			allowed = restrictedCapabilities
+		}
+		forbidden := []string{}
+		for _, capability := range capabilities {
+			if !contains(allowed, string(capability)) {
+				forbidden = append(forbidden, string(capability))
+			}
+		}
+		if len(forbidden) > 0 {
+			violations = append(violations, fmt.Sprintf("%s adds the %s capabilities", container, strings.Join(forbidden, ", ")))
+		}
+
+		if seccomp == "" {
+			seccomp = podSeccomp
+		}
+		if seccomp == corev1.SeccompProfileTypeUnconfined || (restricted && seccomp == "") {
+			violations = append(violations, fmt.Sprintf("%s does not use the RuntimeDefault seccomp profile", container))
+		}
+
+		if !restricted {
+			return
+		}
+		if runAsUser != nil && *runAsUser == 0 {
+			violations = append(violations, fmt.Sprintf("%s runs as root", container))
+		} else if !(runAsNonRoot != nil && *runAsNonRoot) && !podNonRoot {
+			violations = append(violations, fmt.Sprintf("%s does not set runAsNonRoot", container))
+		}
+		if allowPrivilegeEscalation == nil || *allowPrivilegeEscalation {
+			violations = append(violations, fmt.Sprintf("%s allows privilege escalation", container))
+		}
+	}
+
+	// qtap-init routes the egress of the pod with iptables, which needs capabilities neither standard allows. The
+	// proxy-env mode routes with environment variables instead.
+	if egressType != EgressType_PROXY_ENV {
+		qtapInit := settings.Init
+		check("qtap-init", qtapInit.Privileged, qtapInit.Capabilities, qtapInit.RunAsUser, qtapInit.RunAsNonRoot, qtapInit.AllowPrivilegeEscalation, qtapInit.SeccompProfile)
+	}
+
+	if egressType == EgressType_INJECT || (egressType == EgressType_PROXY_ENV && settings.ProxyEnvTarget == ProxyEnvTarget_SIDECAR) {
+		qtap := settings.Qtap
+		runAsNonRoot := qtap.RunAsNonRoot
+		if runAsNonRoot == nil && qtap.Uid != nil && *qtap.Uid != 0 {
+			runAsNonRoot = ptr(true)
+		}
+		check("qtap", nil, nil, qtap.Uid, runAsNonRoot, qtap.AllowPrivilegeEscalation, qtap.SeccompProfile)
+	}
+
+	// the merge mode builds the CA bundle with qtap-ca-init, which runs as the application container
+	if settings.InjectCa && settings.CaMode == CaMode_MERGE {
+		if source := caMergeSource(pod, settings); source != nil {
+			sc := caMergeSecurityContext(source)
+			check("qtap-ca-init", nil, nil, sc.RunAsUser, sc.RunAsNonRoot, sc.AllowPrivilegeEscalation, sc.SeccompProfile.Type)
+		}
+	}
+
+	return violations
+}
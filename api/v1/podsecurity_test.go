@@ -0,0 +1,282 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodSecurityViolations(t *testing.T) {
+	// qtap-init without the capabilities, and qtap as a non-root user
+	hardened := map[string]string{
+		"qpoint.io/qtap-init-capabilities":      "NET_BIND_SERVICE",
+		"qpoint.io/qtap-init-run-as-user":       "1010",
+		"qpoint.io/qtap-init-run-as-non-root":   "true",
+		"qpoint.io/qtap-uid":                    "1010",
+		"qpoint.io/qtap-init-seccomp-profile":   "RuntimeDefault",
+		"qpoint.io/qtap-seccomp-profile":        "RuntimeDefault",
+		"qpoint.io/qtap-init-run-as-privileged": "false",
+	}
+	with := func(base map[string]string, key string, value string) map[string]string {
+		annotations := map[string]string{}
+		for k, v := range base {
+			annotations[k] = v
+		}
+		annotations[key] = value
+		return annotations
+	}
+	merge := map[string]string{"qpoint.io/inject-ca": "true", "qpoint.io/inject-ca-mode": "merge"}
+	runAs := func(uid int64, nonRoot *bool) func(pod *corev1.Pod) {
+		return func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{RunAsUser: ptr(uid), RunAsNonRoot: nonRoot}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		level       string
+		egressType  EgressType
+		annotations map[string]string
+		pod         func(pod *corev1.Pod)
+		want        []string
+	}{
+		{
+			name:       "the privileged standard allows everything",
+			level:      PodSecurityLevel_PRIVILEGED,
+			egressType: EgressType_INJECT,
+			want:       []string{},
+		},
+		{
+			name:       "no standard",
+			egressType: EgressType_INJECT,
+			want:       []string{},
+		},
+
+		// qtap-init
+		{
+			name:       "baseline forbids the capabilities of qtap-init",
+			level:      PodSecurityLevel_BASELINE,
+			egressType: EgressType_SERVICE,
+			want:       []string{"qtap-init adds the NET_ADMIN, NET_RAW capabilities"},
+		},
+		{
+			name:        "baseline forbids a privileged qtap-init",
+			level:       PodSecurityLevel_BASELINE,
+			egressType:  EgressType_SERVICE,
+			annotations: with(hardened, "qpoint.io/qtap-init-run-as-privileged", "true"),
+			want:        []string{"qtap-init runs privileged"},
+		},
+		{
+			name:        "baseline forbids an unconfined qtap-init",
+			level:       PodSecurityLevel_BASELINE,
+			egressType:  EgressType_SERVICE,
+			annotations: with(hardened, "qpoint.io/qtap-init-seccomp-profile", "Unconfined"),
+			want:        []string{"qtap-init does not use the RuntimeDefault seccomp profile"},
+		},
+		{
+			name:        "baseline allows qtap-init as root",
+			level:       PodSecurityLevel_BASELINE,
+			egressType:  EgressType_SERVICE,
+			annotations: with(hardened, "qpoint.io/qtap-init-run-as-user", "0"),
+			want:        []string{},
+		},
+		{
+			name:       "restricted forbids the capabilities and a qtap-init that may run as root",
+			level:      PodSecurityLevel_RESTRICTED,
+			egressType: EgressType_SERVICE,
+			want:       []string{"qtap-init adds the NET_ADMIN, NET_RAW capabilities", "qtap-init does not set runAsNonRoot"},
+		},
+		{
+			name:        "restricted forbids qtap-init as root",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_SERVICE,
+			annotations: with(hardened, "qpoint.io/qtap-init-run-as-user", "0"),
+			want:        []string{"qtap-init runs as root"},
+		},
+		{
+			name:        "restricted forbids privilege escalation of qtap-init",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_SERVICE,
+			annotations: with(hardened, "qpoint.io/qtap-init-allow-privilege-escalation", "true"),
+			want:        []string{"qtap-init allows privilege escalation"},
+		},
+		{
+			name:        "restricted allows a hardened qtap-init",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_SERVICE,
+			annotations: hardened,
+			want:        []string{},
+		},
+		{
+			name:       "runAsNonRoot of the pod applies to qtap-init",
+			level:      PodSecurityLevel_RESTRICTED,
+			egressType: EgressType_SERVICE,
+			annotations: map[string]string{
+				"qpoint.io/qtap-init-capabilities": "NET_BIND_SERVICE",
+			},
+			pod: func(pod *corev1.Pod) {
+				pod.Spec.SecurityContext = &corev1.PodSecurityContext{RunAsNonRoot: ptr(true)}
+			},
+			want: []string{},
+		},
+
+		// qtap
+		{
+			name:        "baseline allows qtap",
+			level:       PodSecurityLevel_BASELINE,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{},
+			want:        []string{},
+		},
+		{
+			name:        "baseline forbids an unconfined qtap",
+			level:       PodSecurityLevel_BASELINE,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{"qpoint.io/qtap-seccomp-profile": "Unconfined"},
+			want:        []string{"qtap does not use the RuntimeDefault seccomp profile"},
+		},
+		{
+			name:        "restricted forbids a qtap without a user",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{},
+			want:        []string{"qtap does not set runAsNonRoot"},
+		},
+		{
+			name:        "restricted forbids qtap as root",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{"qpoint.io/qtap-uid": "0"},
+			want:        []string{"qtap runs as root"},
+		},
+		{
+			name:        "restricted forbids privilege escalation of qtap",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{"qpoint.io/qtap-uid": "1010", "qpoint.io/qtap-allow-privilege-escalation": "true"},
+			want:        []string{"qtap allows privilege escalation"},
+		},
+		{
+			name:        "restricted allows qtap as a non-root user",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{"qpoint.io/qtap-uid": "1010"},
+			want:        []string{},
+		},
+		{
+			name:        "the service target of the proxy-env mode injects nothing",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{"qpoint.io/proxy-env-target": "service"},
+			want:        []string{},
+		},
+		{
+			name:        "the inject mode checks qtap-init and qtap",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_INJECT,
+			annotations: with(hardened, "qpoint.io/qtap-uid", "0"),
+			want:        []string{"qtap runs as root"},
+		},
+
+		// qtap-ca-init
+		{
+			name:        "baseline allows qtap-ca-init",
+			level:       PodSecurityLevel_BASELINE,
+			egressType:  EgressType_SERVICE,
+			annotations: with(with(hardened, "qpoint.io/inject-ca", "true"), "qpoint.io/inject-ca-mode", "merge"),
+			pod:         runAs(0, nil),
+			want:        []string{},
+		},
+		{
+			name:        "restricted forbids qtap-ca-init as the root user of the application",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_SERVICE,
+			annotations: with(with(hardened, "qpoint.io/inject-ca", "true"), "qpoint.io/inject-ca-mode", "merge"),
+			pod:         runAs(0, nil),
+			want:        []string{"qtap-ca-init runs as root"},
+		},
+		{
+			name:        "restricted forbids qtap-ca-init when the application may run as root",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: with(merge, "qpoint.io/qtap-uid", "1010"),
+			want:        []string{"qtap-ca-init does not set runAsNonRoot"},
+		},
+		{
+			name:        "restricted allows qtap-ca-init as the non-root user of the application",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: with(merge, "qpoint.io/qtap-uid", "1010"),
+			pod:         runAs(1000, ptr(true)),
+			want:        []string{},
+		},
+		{
+			name:        "the replace mode injects no qtap-ca-init",
+			level:       PodSecurityLevel_RESTRICTED,
+			egressType:  EgressType_PROXY_ENV,
+			annotations: map[string]string{"qpoint.io/inject-ca": "true", "qpoint.io/qtap-uid": "1010"},
+			pod:         runAs(0, nil),
+			want:        []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, errs := ParseSettings(tt.annotations)
+			if len(errs) > 0 {
+				t.Fatalf("invalid annotations: %v", errs.ToAggregate())
+			}
+			pod := testPod(nil)
+			if tt.pod != nil {
+				tt.pod(pod)
+			}
+
+			violations := podSecurityViolations(tt.level, tt.egressType, settings, pod)
+			if violations == nil {
+				violations = []string{}
+			}
+			if !reflect.DeepEqual(violations, tt.want) {
+				t.Errorf("violations %q, want %q", violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookPodSecurity(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace map[string]string
+		denied    bool
+		warned    bool
+	}{
+		{
+			name:      "an enforced standard the injected containers violate",
+			namespace: map[string]string{NAMESPACE_EGRESS_LABEL: "inject", POD_SECURITY_ENFORCE_LABEL: PodSecurityLevel_BASELINE},
+			denied:    true,
+		},
+		{
+			name:      "a standard the namespace warns about",
+			namespace: map[string]string{NAMESPACE_EGRESS_LABEL: "inject", POD_SECURITY_WARN_LABEL: PodSecurityLevel_RESTRICTED},
+			warned:    true,
+		},
+		{
+			name:      "the proxy-env mode in a restricted namespace",
+			namespace: map[string]string{NAMESPACE_EGRESS_LABEL: "proxy-env", POD_SECURITY_ENFORCE_LABEL: PodSecurityLevel_RESTRICTED},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWebhook(t, testNamespaceWith(tt.namespace))
+
+			response := admitPod(t, w, testPod(nil))
+			if response.Allowed == tt.denied {
+				t.Fatalf("allowed %v, want denied %v: %v", response.Allowed, tt.denied, response.Result.Message)
+			}
+			if warned := len(response.Warnings) > 0; warned != tt.warned {
+				t.Errorf("warnings %v, want warned %v", response.Warnings, tt.warned)
+			}
+		})
+	}
+}
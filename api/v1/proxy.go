@@ -0,0 +1,112 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+type ProxyEnvTarget string
+
+const (
+	ProxyEnvTarget_SIDECAR ProxyEnvTarget = "sidecar"
+	ProxyEnvTarget_SERVICE ProxyEnvTarget = "service"
+)
+
+// the destinations that never go through the proxy, extended by the proxy-env-no-proxy annotation
+var defaultNoProxy = []string{"localhost", "127.0.0.1", "::1", ".svc", ".cluster.local"}
+
+// proxyEnv computes the proxy environment variables of the proxy-env egress mode. Clients send plain HTTP and
+// CONNECT requests to a proxy, so both variables point at the HTTP listener of qtap, the https listener only
+// accepts the TLS traffic iptables redirects to it. The sidecar target uses the listen address of qtap, the
+// service target the gateway and the listen port the port mapping maps 80 to.
+func proxyEnv(settings *Settings) ([]corev1.EnvVar, field.ErrorList) {
+	path := field.NewPath("metadata", "annotations")
+	errs := field.ErrorList{}
+
+	proxy := ""
+	switch settings.ProxyEnvTarget {
+	case ProxyEnvTarget_SIDECAR:
+		listen := func(key string, address *ListenAddress) string {
+			if address == nil {
+				errs = append(errs, field.Required(path.Key(ANNOTATION_PREFIX+key), "the proxy-env egress mode with the sidecar target needs the listen address of qtap"))
+				return ""
+			}
+			// qtap listening on every interface is reachable over loopback from the other containers
+			host := address.Host
+			if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+				host = "127.0.0.1"
+			}
+			return proxyUrl(host, address.Port)
+		}
+		proxy = listen("qtap-egress-http-listen", settings.Qtap.EgressHttpListen)
+	case ProxyEnvTarget_SERVICE:
+		host := settings.Init.EgressToDomain
+		if host == "" {
+			host = settings.Init.EgressToAddr
+		}
+		if host == "" {
+			errs = append(errs, field.Required(path.Key(ANNOTATION_PREFIX+"qtap-init-egress-to-domain"), "the proxy-env egress mode with the service target needs the domain or address of the gateway"))
+		}
+
+		mappingPath := path.Key(ANNOTATION_PREFIX + "qtap-init-egress-port-mapping")
+		listenPort := func(destination int32) int32 {
+			for _, mapping := range splitList(settings.Init.EgressPortMapping) {
+				from, to, _ := strings.Cut(mapping, ":")
+				if port, _ := parsePort(to); port == destination {
+					listen, _ := parsePort(from)
+					return listen
+				}
+			}
+			errs = append(errs, field.Invalid(mappingPath, settings.Init.EgressPortMapping, fmt.Sprintf("the proxy-env egress mode with the service target needs a mapping for port %d", destination)))
+			return 0
+		}
+		if port := listenPort(80); host != "" && port != 0 {
+			proxy = proxyUrl(host, port)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	noProxy := strings.Join(append(append([]string{}, defaultNoProxy...), settings.ProxyEnvNoProxy...), ",")
+
+	// most clients read the upper case variables, curl and a few others only the lower case ones
+	env := []corev1.EnvVar{}
+	for _, v := range []corev1.EnvVar{
+		{Name: "HTTP_PROXY", Value: proxy},
+		{Name: "HTTPS_PROXY", Value: proxy},
+		{Name: "NO_PROXY", Value: noProxy},
+	} {
+		env = append(env, v, corev1.EnvVar{Name: strings.ToLower(v.Name), Value: v.Value})
+	}
+
+	return env, nil
+}
+
+func proxyUrl(host string, port int32) string {
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// MutateProxyEnv points the containers of the pod at qtap with the proxy environment variables. Unlike the
+// other egress modes no qtap-init container is injected, so the pod needs no NET_ADMIN capability, but only
+// clients honoring the variables go through qtap. Variables the containers already set are left untouched.
+func MutateProxyEnv(pod *corev1.Pod, config *Config) error {
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name == "qtap" || contains(config.Settings.ExcludeContainers, container.Name) {
+			continue
+		}
+		for _, env := range config.proxyEnv {
+			container.Env = setEnvDefault(container.Env, env.Name, env.Value)
+		}
+	}
+
+	// gtg
+	return nil
+}
@@ -0,0 +1,80 @@
+package v1
+
+import (
+	"testing"
+)
+
+func TestProxyEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		proxy    string
+		noProxy  string
+	}{
+		{
+			name: "the sidecar target points at the http listener of qtap",
+			settings: Settings{
+				ProxyEnvTarget: ProxyEnvTarget_SIDECAR,
+				Qtap: QtapSettings{
+					EgressHttpListen:  &ListenAddress{Host: "0.0.0.0", Port: 10080},
+					EgressHttpsListen: &ListenAddress{Host: "0.0.0.0", Port: 10443},
+				},
+			},
+			proxy:   "http://127.0.0.1:10080",
+			noProxy: "localhost,127.0.0.1,::1,.svc,.cluster.local",
+		},
+		{
+			name: "the service target points at the port of the gateway mapped to 80",
+			settings: Settings{
+				ProxyEnvTarget:  ProxyEnvTarget_SERVICE,
+				ProxyEnvNoProxy: []string{"example.com"},
+				Init: InitSettings{
+					EgressToDomain:    "qtap-gateway.qpoint.svc.cluster.local",
+					EgressPortMapping: "10080:80,10443:443",
+				},
+			},
+			proxy:   "http://qtap-gateway.qpoint.svc.cluster.local:10080",
+			noProxy: "localhost,127.0.0.1,::1,.svc,.cluster.local,example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, errs := proxyEnv(&tt.settings)
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs.ToAggregate())
+			}
+
+			want := map[string]string{
+				"HTTP_PROXY":  tt.proxy,
+				"http_proxy":  tt.proxy,
+				"HTTPS_PROXY": tt.proxy,
+				"https_proxy": tt.proxy,
+				"NO_PROXY":    tt.noProxy,
+				"no_proxy":    tt.noProxy,
+			}
+			if len(env) != len(want) {
+				t.Fatalf("env %v, want %v", env, want)
+			}
+			for _, v := range env {
+				if v.Value != want[v.Name] {
+					t.Errorf("%s=%q, want %q", v.Name, v.Value, want[v.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestProxyEnvRequiresHttpMapping(t *testing.T) {
+	settings := &Settings{
+		ProxyEnvTarget: ProxyEnvTarget_SERVICE,
+		Init: InitSettings{
+			EgressToAddr:      "10.0.0.1",
+			EgressPortMapping: "10443:443",
+		},
+	}
+
+	if env, errs := proxyEnv(settings); len(errs) == 0 {
+		t.Fatalf("env %v without a mapping for port 80, want an error", env)
+	}
+}
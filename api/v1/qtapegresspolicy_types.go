@@ -0,0 +1,494 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QtapEgressPolicySpec defines the defaults applied to pods that are mutated for a given egress mode.
+// Every field is optional; only the fields that are set are applied to the pod as defaults.
+// +kubebuilder:object:generate=true
+type QtapEgressPolicySpec struct {
+	// Mode is the egress mode this policy provides defaults for.
+	// +kubebuilder:validation:Enum=service;inject;proxy-env
+	Mode EgressType `json:"mode"`
+
+	// Enforce keeps the pods of the namespaces in this mode from disabling egress with the qpoint.io/egress
+	// label, unless they are exempted in the qtap-operator-egress-exemptions-configmap.
+	// +optional
+	Enforce bool `json:"enforce,omitempty"`
+
+	// InjectCa determines if the Qpoint CA bundle is mounted into the application containers.
+	// +optional
+	InjectCa *bool `json:"injectCa,omitempty"`
+
+	// InjectCaMode determines how the Qpoint CA is injected. The replace mode mounts the bundles maintained by
+	// the operator over the trust store of the containers, the merge mode appends the Qpoint CA to the trust
+	// store of the application image with an init container (which requires a shell in the image).
+	// +kubebuilder:validation:Enum=replace;merge
+	// +optional
+	InjectCaMode CaMode `json:"injectCaMode,omitempty"`
+
+	// InjectCaJava mounts a PKCS12 truststore with the Java roots and the Qpoint CA into the application
+	// containers and points the JVM at it with JAVA_TOOL_OPTIONS.
+	// +optional
+	InjectCaJava *bool `json:"injectCaJava,omitempty"`
+
+	// InjectCaEnv lists the runtimes whose CA environment variables (such as NODE_EXTRA_CA_CERTS or
+	// REQUESTS_CA_BUNDLE) are pointed at the CA bundle. Variables the containers already set are left as is.
+	// +optional
+	InjectCaEnv []CaEnvRuntime `json:"injectCaEnv,omitempty"`
+
+	// InjectCaConflictPolicy determines what happens when a container already mounts something at a path the
+	// CA is mounted to: skip the path, replace the existing mount or fail the admission of the pod.
+	// +kubebuilder:validation:Enum=skip;replace;fail
+	// +optional
+	InjectCaConflictPolicy ConflictPolicy `json:"injectCaConflictPolicy,omitempty"`
+
+	// InjectCaContainers are the names of the containers that receive the CA. Every container receives the
+	// CA when empty.
+	// +optional
+	InjectCaContainers []string `json:"injectCaContainers,omitempty"`
+
+	// InjectCaInitContainers are the names of the init containers that receive the CA.
+	// +optional
+	InjectCaInitContainers []string `json:"injectCaInitContainers,omitempty"`
+
+	// ExcludeContainers are the names of the containers (including the qtap sidecar) that are excluded from CA
+	// injection. The egress of excluded containers that set securityContext.runAsUser is not routed through qtap.
+	// +optional
+	ExcludeContainers []string `json:"excludeContainers,omitempty"`
+
+	// TokenSecret is the name of the secret in the operator namespace with the Qpoint token the qtap sidecar
+	// uses. It defaults to the token secret.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`
+	// +optional
+	TokenSecret string `json:"tokenSecret,omitempty"`
+
+	// ImagePullPolicy is the image pull policy of the qtap-init and qtap containers.
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// Init configures the qtap-init container which manages the egress routing of the pod. The proxy-env mode
+	// injects no qtap-init container, but points at the gateway and port mapping configured here.
+	// +optional
+	Init QtapInitSpec `json:"init,omitempty"`
+
+	// Qtap configures the qtap sidecar container. It only applies to the inject and proxy-env modes.
+	// +optional
+	Qtap QtapSpec `json:"qtap,omitempty"`
+
+	// ProxyEnv configures the proxy environment variables of the proxy-env mode.
+	// +optional
+	ProxyEnv QtapProxyEnvSpec `json:"proxyEnv,omitempty"`
+}
+
+// QtapProxyEnvSpec configures the proxy environment variables of the proxy-env mode
+// +kubebuilder:object:generate=true
+type QtapProxyEnvSpec struct {
+	// Target is where HTTP_PROXY and HTTPS_PROXY point to, either the qtap sidecar injected into the pod or the
+	// gateway service configured in init. It defaults to the sidecar.
+	// +kubebuilder:validation:Enum=sidecar;service
+	// +optional
+	Target ProxyEnvTarget `json:"target,omitempty"`
+
+	// NoProxy are additional NO_PROXY entries, such as the domains of internal services.
+	// +optional
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+// CaEnvRuntime is a runtime with CA environment variables
+// +kubebuilder:validation:Enum=node;python;ruby;curl;openssl;git;aws
+type CaEnvRuntime string
+
+// QtapInitSpec configures the qtap-init container
+// +kubebuilder:object:generate=true
+type QtapInitSpec struct {
+	// Tag is the image tag of the qtap-init image.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Repository is the image repository of the qtap-init image, such as a mirror in a private registry. It
+	// defaults to the repository configured for the operator.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Digest pins the qtap-init image to a sha256 digest, which takes precedence over the tag.
+	// +kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// RunAsUser is the UID the qtap-init container runs as.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+
+	// RunAsGroup is the GID the qtap-init container runs as.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RunAsGroup *int64 `json:"runAsGroup,omitempty"`
+
+	// RunAsNonRoot requires the qtap-init container to run as a non-root user.
+	// +optional
+	RunAsNonRoot *bool `json:"runAsNonRoot,omitempty"`
+
+	// Privileged runs the qtap-init container as privileged.
+	// +optional
+	Privileged *bool `json:"privileged,omitempty"`
+
+	// Capabilities are added to the qtap-init container, every other capability is dropped. It defaults to
+	// NET_ADMIN and NET_RAW.
+	// +kubebuilder:validation:items:Pattern=`^(CAP_)?[A-Z][A-Z_]*$`
+	// +optional
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// AllowPrivilegeEscalation allows the qtap-init container to gain more privileges. It defaults to false.
+	// +optional
+	AllowPrivilegeEscalation *bool `json:"allowPrivilegeEscalation,omitempty"`
+
+	// ReadOnlyRootFilesystem mounts the root filesystem of the qtap-init container as read-only.
+	// +optional
+	ReadOnlyRootFilesystem *bool `json:"readOnlyRootFilesystem,omitempty"`
+
+	// SeccompProfile is the seccomp profile type of the qtap-init container. It defaults to RuntimeDefault.
+	// +kubebuilder:validation:Enum=RuntimeDefault;Unconfined
+	// +optional
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+
+	// EgressToAddr is the address egress traffic is routed to.
+	// +optional
+	EgressToAddr string `json:"egressToAddr,omitempty"`
+
+	// EgressToDomain is the domain egress traffic is routed to.
+	// +optional
+	EgressToDomain string `json:"egressToDomain,omitempty"`
+
+	// EgressPortMapping is a comma separated list of <listen port>:<destination port> pairs.
+	// +kubebuilder:validation:Pattern=`^[0-9]+:[0-9]+(,[0-9]+:[0-9]+)*$`
+	// +optional
+	EgressPortMapping string `json:"egressPortMapping,omitempty"`
+
+	// EgressAcceptUids are the UIDs whose traffic is not routed through qtap.
+	// +optional
+	EgressAcceptUids []int64 `json:"egressAcceptUids,omitempty"`
+
+	// EgressAcceptGids are the GIDs whose traffic is not routed through qtap.
+	// +optional
+	EgressAcceptGids []int64 `json:"egressAcceptGids,omitempty"`
+
+	// CpuRequest is the CPU request of the qtap-init container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	CpuRequest string `json:"cpuRequest,omitempty"`
+
+	// CpuLimit is the CPU limit of the qtap-init container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	CpuLimit string `json:"cpuLimit,omitempty"`
+
+	// MemoryRequest is the memory request of the qtap-init container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	MemoryRequest string `json:"memoryRequest,omitempty"`
+
+	// MemoryLimit is the memory limit of the qtap-init container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	MemoryLimit string `json:"memoryLimit,omitempty"`
+}
+
+// QtapSpec configures the qtap sidecar container
+// +kubebuilder:object:generate=true
+type QtapSpec struct {
+	// Tag is the image tag of the qtap image.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Repository is the image repository of the qtap image, such as a mirror in a private registry. It
+	// defaults to the repository configured for the operator.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Digest pins the qtap image to a sha256 digest, which takes precedence over the tag.
+	// +kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// Uid is the UID the qtap container runs as.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Uid *int64 `json:"uid,omitempty"`
+
+	// Gid is the GID the qtap container runs as.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Gid *int64 `json:"gid,omitempty"`
+
+	// RunAsNonRoot requires the qtap container to run as a non-root user. It defaults to true when the uid is
+	// not 0.
+	// +optional
+	RunAsNonRoot *bool `json:"runAsNonRoot,omitempty"`
+
+	// AllowPrivilegeEscalation allows the qtap container to gain more privileges. It defaults to false.
+	// +optional
+	AllowPrivilegeEscalation *bool `json:"allowPrivilegeEscalation,omitempty"`
+
+	// ReadOnlyRootFilesystem mounts the root filesystem of the qtap container as read-only, with a writable
+	// /tmp. It defaults to true.
+	// +optional
+	ReadOnlyRootFilesystem *bool `json:"readOnlyRootFilesystem,omitempty"`
+
+	// SeccompProfile is the seccomp profile type of the qtap container. It defaults to RuntimeDefault.
+	// +kubebuilder:validation:Enum=RuntimeDefault;Unconfined
+	// +optional
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+
+	// LogLevel is the log level of qtap.
+	// +kubebuilder:validation:Enum=debug;info;warn;error;dpanic;panic;fatal
+	// +optional
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// LogEncoding is the log encoding of qtap.
+	// +kubebuilder:validation:Enum=json;console
+	// +optional
+	LogEncoding string `json:"logEncoding,omitempty"`
+
+	// LogCaller adds the caller to the qtap logs.
+	// +optional
+	LogCaller *bool `json:"logCaller,omitempty"`
+
+	// EgressHttpListen is the host:port qtap listens on for HTTP egress traffic.
+	// +kubebuilder:validation:Pattern=`^.*:[0-9]+$`
+	// +optional
+	EgressHttpListen string `json:"egressHttpListen,omitempty"`
+
+	// EgressHttpsListen is the host:port qtap listens on for HTTPS egress traffic.
+	// +kubebuilder:validation:Pattern=`^.*:[0-9]+$`
+	// +optional
+	EgressHttpsListen string `json:"egressHttpsListen,omitempty"`
+
+	// StatusListen is the host:port qtap serves its health and readiness endpoints on.
+	// +kubebuilder:validation:Pattern=`^.*:[0-9]+$`
+	// +optional
+	StatusListen string `json:"statusListen,omitempty"`
+
+	// BlockUnknown blocks traffic qtap is unable to identify.
+	// +optional
+	BlockUnknown *bool `json:"blockUnknown,omitempty"`
+
+	// EnvoyLogLevel is the log level of envoy within qtap.
+	// +kubebuilder:validation:Enum=trace;debug;info;warning;warn;error;critical;off
+	// +optional
+	EnvoyLogLevel string `json:"envoyLogLevel,omitempty"`
+
+	// DnsLookupFamily is the DNS lookup family used by qtap.
+	// +kubebuilder:validation:Enum=AUTO;V4_ONLY;V6_ONLY;V4_PREFERRED;ALL
+	// +optional
+	DnsLookupFamily string `json:"dnsLookupFamily,omitempty"`
+
+	// ApiEndpoint is the Qpoint API endpoint qtap reports to.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	ApiEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// LabelsTagsFilter is a list of regular expressions matched against the pod label keys. Matching
+	// labels are added as tags to qtap.
+	// +optional
+	LabelsTagsFilter []string `json:"labelsTagsFilter,omitempty"`
+
+	// CpuRequest is the CPU request of the qtap container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	CpuRequest string `json:"cpuRequest,omitempty"`
+
+	// CpuLimit is the CPU limit of the qtap container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	CpuLimit string `json:"cpuLimit,omitempty"`
+
+	// MemoryRequest is the memory request of the qtap container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	MemoryRequest string `json:"memoryRequest,omitempty"`
+
+	// MemoryLimit is the memory limit of the qtap container. It defaults to the LimitRange of the namespace.
+	// +kubebuilder:validation:Pattern=`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// +optional
+	MemoryLimit string `json:"memoryLimit,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:object:generate=true
+// +kubebuilder:resource:scope=Namespaced,shortName=qep
+// +kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// QtapEgressPolicy provides the defaults for pods mutated within its namespace. It takes precedence over a
+// ClusterQtapEgressPolicy for the same mode.
+type QtapEgressPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec QtapEgressPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:object:generate=true
+
+// QtapEgressPolicyList contains a list of QtapEgressPolicy
+type QtapEgressPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []QtapEgressPolicy `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:object:generate=true
+// +kubebuilder:resource:scope=Cluster,shortName=cqep
+// +kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterQtapEgressPolicy provides the defaults for pods mutated in any namespace without a QtapEgressPolicy
+// for the same mode.
+type ClusterQtapEgressPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec QtapEgressPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:object:generate=true
+
+// ClusterQtapEgressPolicyList contains a list of ClusterQtapEgressPolicy
+type ClusterQtapEgressPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterQtapEgressPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&QtapEgressPolicy{}, &QtapEgressPolicyList{})
+	SchemeBuilder.Register(&ClusterQtapEgressPolicy{}, &ClusterQtapEgressPolicyList{})
+}
+
+// Annotations converts the policy into the equivalent set of qpoint.io annotations. Only the fields that
+// are set are returned so that the result can be applied as defaults in the same way the annotation
+// configmaps are.
+func (s *QtapEgressPolicySpec) Annotations() map[string]string {
+	annotations := map[string]string{}
+
+	set := func(key string, value string) {
+		if value != "" {
+			annotations["qpoint.io/"+key] = value
+		}
+	}
+	setBool := func(key string, value *bool) {
+		if value != nil {
+			set(key, strconv.FormatBool(*value))
+		}
+	}
+	setInt := func(key string, value *int64) {
+		if value != nil {
+			set(key, strconv.FormatInt(*value, 10))
+		}
+	}
+	setInts := func(key string, values []int64) {
+		ids := make([]string, 0, len(values))
+		for _, v := range values {
+			ids = append(ids, strconv.FormatInt(v, 10))
+		}
+		set(key, strings.Join(ids, ","))
+	}
+
+	set("token-secret", s.TokenSecret)
+	setBool("inject-ca", s.InjectCa)
+	set("inject-ca-mode", string(s.InjectCaMode))
+	setBool("inject-ca-java", s.InjectCaJava)
+	runtimes := make([]string, 0, len(s.InjectCaEnv))
+	for _, runtime := range s.InjectCaEnv {
+		runtimes = append(runtimes, string(runtime))
+	}
+	set("inject-ca-env", strings.Join(runtimes, ","))
+	set("inject-ca-conflict-policy", string(s.InjectCaConflictPolicy))
+	set("inject-ca-containers", strings.Join(s.InjectCaContainers, ","))
+	set("inject-ca-init-containers", strings.Join(s.InjectCaInitContainers, ","))
+	set("exclude-containers", strings.Join(s.ExcludeContainers, ","))
+	set("image-pull-policy", string(s.ImagePullPolicy))
+	set("proxy-env-target", string(s.ProxyEnv.Target))
+	set("proxy-env-no-proxy", strings.Join(s.ProxyEnv.NoProxy, ","))
+
+	// qtap-init
+	set("qtap-init-tag", s.Init.Tag)
+	set("qtap-init-repository", s.Init.Repository)
+	set("qtap-init-digest", s.Init.Digest)
+	setInt("qtap-init-run-as-user", s.Init.RunAsUser)
+	setInt("qtap-init-run-as-group", s.Init.RunAsGroup)
+	setBool("qtap-init-run-as-non-root", s.Init.RunAsNonRoot)
+	setBool("qtap-init-run-as-privileged", s.Init.Privileged)
+	set("qtap-init-capabilities", strings.Join(s.Init.Capabilities, ","))
+	setBool("qtap-init-allow-privilege-escalation", s.Init.AllowPrivilegeEscalation)
+	setBool("qtap-init-read-only-root-filesystem", s.Init.ReadOnlyRootFilesystem)
+	set("qtap-init-seccomp-profile", s.Init.SeccompProfile)
+	set("qtap-init-egress-to-addr", s.Init.EgressToAddr)
+	set("qtap-init-egress-to-domain", s.Init.EgressToDomain)
+	set("qtap-init-egress-port-mapping", s.Init.EgressPortMapping)
+	setInts("qtap-init-egress-accept-uids", s.Init.EgressAcceptUids)
+	setInts("qtap-init-egress-accept-gids", s.Init.EgressAcceptGids)
+	set("qtap-init-cpu-request", s.Init.CpuRequest)
+	set("qtap-init-cpu-limit", s.Init.CpuLimit)
+	set("qtap-init-memory-request", s.Init.MemoryRequest)
+	set("qtap-init-memory-limit", s.Init.MemoryLimit)
+
+	// qtap
+	set("qtap-tag", s.Qtap.Tag)
+	set("qtap-repository", s.Qtap.Repository)
+	set("qtap-digest", s.Qtap.Digest)
+	setInt("qtap-uid", s.Qtap.Uid)
+	setInt("qtap-gid", s.Qtap.Gid)
+	setBool("qtap-run-as-non-root", s.Qtap.RunAsNonRoot)
+	setBool("qtap-allow-privilege-escalation", s.Qtap.AllowPrivilegeEscalation)
+	setBool("qtap-read-only-root-filesystem", s.Qtap.ReadOnlyRootFilesystem)
+	set("qtap-seccomp-profile", s.Qtap.SeccompProfile)
+	set("qtap-log-level", s.Qtap.LogLevel)
+	set("qtap-log-encoding", s.Qtap.LogEncoding)
+	setBool("qtap-log-caller", s.Qtap.LogCaller)
+	set("qtap-egress-http-listen", s.Qtap.EgressHttpListen)
+	set("qtap-egress-https-listen", s.Qtap.EgressHttpsListen)
+	set("qtap-status-listen", s.Qtap.StatusListen)
+	setBool("qtap-block-unknown", s.Qtap.BlockUnknown)
+	set("qtap-envoy-log-level", s.Qtap.EnvoyLogLevel)
+	set("qtap-dns-lookup-family", s.Qtap.DnsLookupFamily)
+	set("qtap-api-endpoint", s.Qtap.ApiEndpoint)
+	set("qtap-labels-tags-filter", strings.Join(s.Qtap.LabelsTagsFilter, ","))
+	set("qtap-cpu-request", s.Qtap.CpuRequest)
+	set("qtap-cpu-limit", s.Qtap.CpuLimit)
+	set("qtap-memory-request", s.Qtap.MemoryRequest)
+	set("qtap-memory-limit", s.Qtap.MemoryLimit)
+
+	return annotations
+}
@@ -0,0 +1,114 @@
+package v1
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// limitRangeDefaults are the default requests and limits for containers of the LimitRanges in the namespace, along
+// with the lowest max of each resource. The LimitRanger admission plugin runs before the webhook, so the
+// containers the webhook adds don't receive the defaults.
+func (c *Config) limitRangeDefaults() (corev1.ResourceRequirements, corev1.ResourceList, error) {
+	defaults := corev1.ResourceRequirements{}
+	max := corev1.ResourceList{}
+
+	limitRanges := &corev1.LimitRangeList{}
+	if err := c.Client.List(c.Ctx, limitRanges, client.InNamespace(c.Namespace)); err != nil {
+		return defaults, max, fmt.Errorf("listing limit ranges at namespace '%s' from the api: %w", c.Namespace, err)
+	}
+
+	// the first limit range by name wins when several set a default for the same resource
+	sort.Slice(limitRanges.Items, func(i, j int) bool { return limitRanges.Items[i].Name < limitRanges.Items[j].Name })
+	for _, limitRange := range limitRanges.Items {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			defaults.Requests = mergeResources(defaults.Requests, item.DefaultRequest)
+			defaults.Limits = mergeResources(defaults.Limits, item.Default)
+
+			// every limit range is enforced, so the lowest max applies
+			for name, quantity := range item.Max {
+				if current, exists := max[name]; !exists || quantity.Cmp(current) < 0 {
+					max[name] = quantity.DeepCopy()
+				}
+			}
+		}
+	}
+
+	return defaults, max, nil
+}
+
+// containerResources fills the requests and limits the annotations don't set from the defaults of the namespace.
+// A default that conflicts with what the annotations set (a limit below the request) is adjusted to keep the
+// container admissible and reported as a warning. A limit is never dropped, as a ResourceQuota on limits rejects
+// containers without one.
+func (c *Config) containerResources(container string, resources corev1.ResourceRequirements) corev1.ResourceRequirements {
+	requests := mergeResources(resources.DeepCopy().Requests, c.resourceDefaults.Requests)
+	limits := mergeResources(resources.DeepCopy().Limits, c.resourceDefaults.Limits)
+
+	for name, limit := range limits {
+		request, exists := requests[name]
+		if !exists || request.Cmp(limit) <= 0 {
+			continue
+		}
+		if _, set := resources.Limits[name]; !set {
+			// the default limit is below the request of the annotations, the limit is raised to the request which
+			// the max of the namespace was checked against
+			limits[name] = request.DeepCopy()
+			c.Warnings = append(c.Warnings, fmt.Sprintf("the %s request %s of container '%s' exceeds the default limit %s of the namespace, the limit is raised to the request",
+				name, request.String(), container, limit.String()))
+		} else if _, set := resources.Requests[name]; !set {
+			// the default request is above the limit of the annotations, the request is lowered to the limit
+			requests[name] = limit.DeepCopy()
+			c.Warnings = append(c.Warnings, fmt.Sprintf("the %s limit %s of container '%s' is below the default request %s of the namespace, the request is lowered to the limit",
+				name, limit.String(), container, request.String()))
+		}
+	}
+
+	return corev1.ResourceRequirements{
+		Requests: requests,
+		Limits:   limits,
+	}
+}
+
+// resourceMaxErrors rejects the requests and limits the annotations set above the max of the LimitRanges in the
+// namespace, which the api would reject with a message that doesn't name the annotation
+func (c *Config) resourceMaxErrors(container string, resources corev1.ResourceRequirements) field.ErrorList {
+	errs := field.ErrorList{}
+	path := field.NewPath("metadata", "annotations")
+
+	check := func(kind string, list corev1.ResourceList) {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			quantity, set := list[name]
+			max, limited := c.resourceMax[name]
+			if !set || !limited || quantity.Cmp(max) <= 0 {
+				continue
+			}
+			key := fmt.Sprintf("%s%s-%s-%s", ANNOTATION_PREFIX, container, name, kind)
+			errs = append(errs, field.Invalid(path.Key(key), quantity.String(), fmt.Sprintf("exceeds the %s max %s of the limit ranges of namespace '%s'", name, max.String(), c.Namespace)))
+		}
+	}
+	check("request", resources.Requests)
+	check("limit", resources.Limits)
+
+	return errs
+}
+
+// mergeResources adds the resources of from that are not in the list yet
+func mergeResources(list corev1.ResourceList, from corev1.ResourceList) corev1.ResourceList {
+	for name, quantity := range from {
+		if _, exists := list[name]; exists {
+			continue
+		}
+		if list == nil {
+			list = corev1.ResourceList{}
+		}
+		list[name] = quantity.DeepCopy()
+	}
+	return list
+}
@@ -0,0 +1,120 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerResources(t *testing.T) {
+	quantities := func(cpu string) corev1.ResourceList {
+		if cpu == "" {
+			return nil
+		}
+		return corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}
+	}
+
+	tests := []struct {
+		name        string
+		requests    string
+		limits      string
+		wantRequest string
+		wantLimit   string
+		warned      bool
+	}{
+		{name: "the defaults fill what isn't set", wantRequest: "100m", wantLimit: "200m"},
+		{name: "a request below the default limit", requests: "150m", wantRequest: "150m", wantLimit: "200m"},
+		{name: "a request above the default limit raises the limit", requests: "500m", wantRequest: "500m", wantLimit: "500m", warned: true},
+		{name: "a limit below the default request lowers the request", limits: "50m", wantRequest: "50m", wantLimit: "50m", warned: true},
+		{name: "conflicting annotations are left as is", requests: "500m", limits: "50m", wantRequest: "500m", wantLimit: "50m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{resourceDefaults: corev1.ResourceRequirements{Requests: quantities("100m"), Limits: quantities("200m")}}
+
+			resources := config.containerResources("qtap", corev1.ResourceRequirements{Requests: quantities(tt.requests), Limits: quantities(tt.limits)})
+
+			if got := resources.Requests[corev1.ResourceCPU]; got.String() != tt.wantRequest {
+				t.Errorf("request %s, want %s", got.String(), tt.wantRequest)
+			}
+			got, exists := resources.Limits[corev1.ResourceCPU]
+			if tt.wantLimit == "" && exists {
+				t.Errorf("limit %s, want none", got.String())
+			}
+			if tt.wantLimit != "" && got.String() != tt.wantLimit {
+				t.Errorf("limit %s, want %s", got.String(), tt.wantLimit)
+			}
+			if warned := len(config.Warnings) > 0; warned != tt.warned {
+				t.Errorf("warnings %v, want warned %v", config.Warnings, tt.warned)
+			}
+		})
+	}
+}
+
+func TestWebhookLimitRangeMax(t *testing.T) {
+	limitRange := func(name string, max string) *corev1.LimitRange {
+		return &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type:           corev1.LimitTypeContainer,
+				Default:        corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+				Max:            corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(max)},
+				DefaultRequest: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			}}},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		denied      string
+		wantLimit   string
+	}{
+		{
+			name:      "the defaults of the namespace",
+			wantLimit: "200m",
+		},
+		{
+			name:        "a request above the default limit raises the limit",
+			annotations: map[string]string{"qpoint.io/qtap-cpu-request": "500m"},
+			wantLimit:   "500m",
+		},
+		{
+			name:        "a request above the lowest max is denied",
+			annotations: map[string]string{"qpoint.io/qtap-cpu-request": "1500m"},
+			denied:      "metadata.annotations[qpoint.io/qtap-cpu-request]",
+		},
+		{
+			name:        "a limit above the lowest max is denied",
+			annotations: map[string]string{"qpoint.io/qtap-init-cpu-limit": "1500m"},
+			denied:      "metadata.annotations[qpoint.io/qtap-init-cpu-limit]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWebhook(t, testNamespaceWith(map[string]string{NAMESPACE_EGRESS_LABEL: "inject"}), limitRange("a", "2"), limitRange("b", "1"))
+
+			pod := testPod(tt.annotations)
+			response := admitPod(t, w, pod)
+			if tt.denied != "" {
+				if response.Allowed || !strings.Contains(response.Result.Message, tt.denied) {
+					t.Fatalf("allowed %v with message %q, want %s denied", response.Allowed, response.Result.Message, tt.denied)
+				}
+				return
+			}
+
+			qtap := findContainer(patchedPod(t, pod, response).Spec.Containers, "qtap")
+			if qtap == nil {
+				t.Fatalf("pod lacks the qtap container")
+			}
+			if limit := qtap.Resources.Limits[corev1.ResourceCPU]; limit.String() != tt.wantLimit {
+				t.Errorf("qtap cpu limit %s, want %s", limit.String(), tt.wantLimit)
+			}
+		})
+	}
+}
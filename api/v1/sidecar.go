@@ -0,0 +1,97 @@
+package v1
+
+import (
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// NativeSidecars determines if qtap is injected as a native sidecar (an init container with restartPolicy Always)
+type NativeSidecars string
+
+const (
+	NativeSidecars_AUTO  NativeSidecars = "auto"
+	NativeSidecars_TRUE  NativeSidecars = "true"
+	NativeSidecars_FALSE NativeSidecars = "false"
+)
+
+// native sidecars are enabled by default from Kubernetes 1.29 (the SidecarContainers feature gate is beta)
+var nativeSidecarsMinVersion = version.MustParseGeneric("1.29.0")
+
+// ResolveNativeSidecars resolves the native sidecars option, detecting the support from the version of the
+// API server in the auto mode
+func ResolveNativeSidecars(option NativeSidecars, config *rest.Config) (bool, error) {
+	switch option {
+	case NativeSidecars_TRUE:
+		return true, nil
+	case NativeSidecars_FALSE:
+		return false, nil
+	case NativeSidecars_AUTO:
+	default:
+		return false, fmt.Errorf("'%s' is not one of auto, true or false", option)
+	}
+
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return false, fmt.Errorf("creating discovery client: %w", err)
+	}
+	info, err := client.ServerVersion()
+	if err != nil {
+		return false, fmt.Errorf("fetching the api server version: %w", err)
+	}
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return false, fmt.Errorf("parsing the api server version '%s': %w", info.GitVersion, err)
+	}
+
+	return serverVersion.AtLeast(nativeSidecarsMinVersion), nil
+}
+
+// JOB_SIDECAR_ANNOTATION marks the pods of Jobs with a qtap container that has to be stopped once the
+// application containers have completed, as it would otherwise keep the pod running forever
+const JOB_SIDECAR_ANNOTATION = "qpoint.io/job-sidecar"
+
+// JOB_SIDECAR_SHUTDOWN_ANNOTATION is set by the job sidecar controller once the application containers have
+// completed. The kubelet writes its value to the shutdown file of qtap, which exits when the file reads true.
+const JOB_SIDECAR_SHUTDOWN_ANNOTATION = "qpoint.io/qtap-shutdown"
+
+// the downward API volume with the shutdown file of qtap
+const QTAP_LIFECYCLE_VOLUME = "qtap-lifecycle"
+const QTAP_LIFECYCLE_DIR = "/var/run/qtap/lifecycle"
+const QTAP_SHUTDOWN_FILE = "shutdown"
+
+// IsJobPod determines if the pod is owned by a Job (which includes the Jobs of CronJobs)
+func IsJobPod(pod *corev1.Pod) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "Job" && owner.APIVersion == batchv1.SchemeGroupVersion.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// addShutdownFile mounts the shutdown annotation of the pod as a file into the qtap container, which qtap watches
+// to exit once the job sidecar controller sets it. Unlike signaling qtap this needs no exec into the pod.
+func addShutdownFile(pod *corev1.Pod, qtapContainer *corev1.Container) {
+	pod.Spec.Volumes = upsertVolume(pod.Spec.Volumes, corev1.Volume{
+		Name: QTAP_LIFECYCLE_VOLUME,
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{{
+					Path:     QTAP_SHUTDOWN_FILE,
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.annotations['%s']", JOB_SIDECAR_SHUTDOWN_ANNOTATION)},
+				}},
+			},
+		},
+	})
+	qtapContainer.VolumeMounts = append(qtapContainer.VolumeMounts, corev1.VolumeMount{
+		Name:      QTAP_LIFECYCLE_VOLUME,
+		MountPath: QTAP_LIFECYCLE_DIR,
+		ReadOnly:  true,
+	})
+	qtapContainer.Env = setEnvDefault(qtapContainer.Env, "SHUTDOWN_FILE", QTAP_LIFECYCLE_DIR+"/"+QTAP_SHUTDOWN_FILE)
+}
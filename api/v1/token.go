@@ -0,0 +1,48 @@
+package v1
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const TOKEN_SECRET = "token"                             // the default secret in the operator namespace with the Qpoint token
+const TOKEN_KEY = "token"                                // the key of the token within the secret
+const TOKEN_SECRET_ANNOTATION = "qpoint.io/token-secret" // selects the token secret of a namespace or pod
+const TOKEN_SOURCE_ANNOTATION = "qpoint.io/token-source" // the token secret a synced copy was made from
+
+// TOKEN_SELECTABLE_LABEL marks the secrets in the operator namespace besides the default token secret that
+// namespaces, pods and policies may select with qpoint.io/token-secret
+const TOKEN_SELECTABLE_LABEL = "qpoint.io/token-selectable"
+
+// the copies of the token secrets synced into each instrumented namespace. The prefix doesn't overlap with the
+// one of the image pull secrets, so that a copy of one is never named like a copy of the other.
+const QTAP_TOKEN_SECRET = "qtap-token"
+const QTAP_TOKEN_SECRET_PREFIX = QTAP_TOKEN_SECRET + "-"
+
+// SyncedTokenSecret is the name of the copy of a token secret within an instrumented namespace. The default
+// token secret is synced as qtap-token and the others as qtap-token-<name>.
+func SyncedTokenSecret(tokenSecret string) string {
+	if tokenSecret == TOKEN_SECRET {
+		return QTAP_TOKEN_SECRET
+	}
+	return QTAP_TOKEN_SECRET_PREFIX + tokenSecret
+}
+
+// IsSelectableToken determines if a secret in the operator namespace may be synced as a token. Only the default
+// token secret and the secrets an administrator labelled are, so that other credentials in the operator
+// namespace can't be selected.
+func IsSelectableToken(secret *corev1.Secret) bool {
+	if _, exists := secret.Data[TOKEN_KEY]; !exists {
+		return false
+	}
+	return secret.Name == TOKEN_SECRET || secret.Labels[TOKEN_SELECTABLE_LABEL] == "true"
+}
+
+// TokenSecretOf determines the token secret a pod uses from its annotations
+func TokenSecretOf(annotations map[string]string) string {
+	if tokenSecret := strings.TrimSpace(annotations[TOKEN_SECRET_ANNOTATION]); tokenSecret != "" {
+		return tokenSecret
+	}
+	return TOKEN_SECRET
+}
@@ -0,0 +1,238 @@
+package v1
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// CA_FIRST_SEEN_ANNOTATION records when the operator first saw each Qpoint CA of a bundle, as a JSON object of
+// fingerprints to times
+const CA_FIRST_SEEN_ANNOTATION = "qpoint.io/ca-first-seen"
+
+// TrustedCa is a Qpoint CA along with the period it should be trusted for. The period defaults to the validity
+// of the certificate but can be narrowed by the CA source, which is how a CA is retired during a rotation.
+// FirstSeen is when the operator first saw the CA, which is zero when unknown.
+type TrustedCa struct {
+	Pem         string
+	Fingerprint string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	FirstSeen   time.Time
+}
+
+// TrustedCaEntry is the format of the CAs listed in the cas.yaml key of the qpoint-qtap-ca.crt configmap
+type TrustedCaEntry struct {
+	Ca        string     `json:"ca"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+}
+
+// ParseTrustedCas parses every certificate in the PEM data. The trust period is the validity of each certificate.
+func ParseTrustedCas(pemData string) ([]TrustedCa, error) {
+	cas := []TrustedCa{}
+
+	rest := []byte(pemData)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate: %w", err)
+		}
+
+		sum := sha256.Sum256(block.Bytes)
+		cas = append(cas, TrustedCa{
+			Pem:         strings.TrimSpace(string(pem.EncodeToMemory(block))),
+			Fingerprint: hex.EncodeToString(sum[:]),
+			NotBefore:   cert.NotBefore,
+			NotAfter:    cert.NotAfter,
+		})
+	}
+
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+
+	return cas, nil
+}
+
+// ParseTrustedCaEntries parses a YAML list of CAs with optional trust periods
+func ParseTrustedCaEntries(data string) ([]TrustedCa, error) {
+	entries := []TrustedCaEntry{}
+	if err := yaml.Unmarshal([]byte(data), &entries); err != nil {
+		return nil, fmt.Errorf("unmarshaling CA list: %w", err)
+	}
+
+	return entriesToTrustedCas(entries)
+}
+
+func entriesToTrustedCas(entries []TrustedCaEntry) ([]TrustedCa, error) {
+	cas := []TrustedCa{}
+	for i, entry := range entries {
+		parsed, err := ParseTrustedCas(entry.Ca)
+		if err != nil {
+			return nil, fmt.Errorf("CA %d: %w", i, err)
+		}
+
+		for _, ca := range parsed {
+			if entry.NotBefore != nil {
+				ca.NotBefore = *entry.NotBefore
+			}
+			if entry.NotAfter != nil {
+				ca.NotAfter = *entry.NotAfter
+			}
+			cas = append(cas, ca)
+		}
+	}
+	return cas, nil
+}
+
+// MergeTrustedCas de-duplicates the CAs by fingerprint. When a CA is listed more than once the last entry wins
+// so that explicit trust periods can override the ones taken from the certificate.
+func MergeTrustedCas(lists ...[]TrustedCa) []TrustedCa {
+	merged := []TrustedCa{}
+	index := map[string]int{}
+
+	for _, list := range lists {
+		for _, ca := range list {
+			if i, exists := index[ca.Fingerprint]; exists {
+				merged[i] = ca
+				continue
+			}
+			index[ca.Fingerprint] = len(merged)
+			merged = append(merged, ca)
+		}
+	}
+
+	return merged
+}
+
+// SelectTrustedCas determines which CAs belong in the bundles at the given time. The current CA is the most
+// recent one that has become valid. CAs that it replaced are kept for the overlap period so that pods still
+// talking to a qtap with certificates from the previous CA keep working, and CAs that are not valid yet are
+// included ahead of a rotation. The overlap starts once the current CA is valid and has been seen, as a CA
+// is usually published some time after it was issued. The time at which the selection changes next is
+// returned so the bundles can be rebuilt then.
+func SelectTrustedCas(cas []TrustedCa, now time.Time, overlap time.Duration) ([]TrustedCa, time.Time) {
+	var next time.Time
+	updateNext := func(t time.Time) {
+		if t.After(now) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+
+	// find the current CA
+	var current *TrustedCa
+	for i := range cas {
+		ca := &cas[i]
+		if !ca.NotBefore.After(now) && ca.NotAfter.After(now) && (current == nil || ca.NotBefore.After(current.NotBefore)) {
+			current = ca
+		}
+	}
+
+	selected := []TrustedCa{}
+	for _, ca := range cas {
+		// expired
+		if !ca.NotAfter.After(now) {
+			continue
+		}
+		updateNext(ca.NotAfter)
+
+		// not valid yet, trusted ahead of the rotation which happens once it becomes valid
+		if ca.NotBefore.After(now) {
+			updateNext(ca.NotBefore)
+			selected = append(selected, ca)
+			continue
+		}
+
+		// replaced by the current CA, trusted until the end of the overlap
+		if current != nil && ca.Fingerprint != current.Fingerprint && ca.NotBefore.Before(current.NotBefore) {
+			rotatedAt := current.NotBefore
+			if current.FirstSeen.After(rotatedAt) {
+				rotatedAt = current.FirstSeen
+			}
+			retiredAt := rotatedAt.Add(overlap)
+			if !retiredAt.After(now) {
+				continue
+			}
+			updateNext(retiredAt)
+		}
+
+		selected = append(selected, ca)
+	}
+
+	// keep the bundles stable regardless of the order of the source
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].NotBefore.Before(selected[j].NotBefore) })
+
+	return selected, next
+}
+
+// ParseCaFirstSeen parses the first seen annotation of a bundle. An invalid annotation is treated as empty, the
+// CAs are then seen for the first time again which only extends the overlap.
+func ParseCaFirstSeen(value string) map[string]time.Time {
+	firstSeen := map[string]time.Time{}
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &firstSeen); err != nil {
+			return map[string]time.Time{}
+		}
+	}
+	return firstSeen
+}
+
+// FormatCaFirstSeen formats the first seen times of the CAs for the annotation of a bundle
+func FormatCaFirstSeen(cas []TrustedCa) string {
+	firstSeen := map[string]time.Time{}
+	for _, ca := range cas {
+		firstSeen[ca.Fingerprint] = ca.FirstSeen.UTC()
+	}
+	// the keys of a map are marshaled in order, which keeps the annotation stable
+	data, _ := json.Marshal(firstSeen)
+	return string(data)
+}
+
+// WithFirstSeen sets when the CAs were first seen, which is now for the CAs missing from firstSeen
+func WithFirstSeen(cas []TrustedCa, firstSeen map[string]time.Time, now time.Time) []TrustedCa {
+	result := make([]TrustedCa, 0, len(cas))
+	for _, ca := range cas {
+		ca.FirstSeen = now.Truncate(time.Second)
+		if seen, exists := firstSeen[ca.Fingerprint]; exists {
+			ca.FirstSeen = seen
+		}
+		result = append(result, ca)
+	}
+	return result
+}
+
+// JoinTrustedCas concatenates the PEM data of the CAs
+func JoinTrustedCas(cas []TrustedCa) string {
+	pems := make([]string, 0, len(cas))
+	for _, ca := range cas {
+		pems = append(pems, ca.Pem)
+	}
+	return strings.Join(pems, "\n")
+}
+
+// JoinFingerprints concatenates the fingerprints of the CAs
+func JoinFingerprints(cas []TrustedCa) string {
+	fingerprints := make([]string, 0, len(cas))
+	for _, ca := range cas {
+		fingerprints = append(fingerprints, ca.Fingerprint)
+	}
+	return strings.Join(fingerprints, ",")
+}
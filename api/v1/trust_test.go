@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSelectTrustedCas(t *testing.T) {
+	day := 24 * time.Hour
+	overlap := 3 * day
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	ca := func(fingerprint string, notBefore time.Time, firstSeen time.Time) TrustedCa {
+		return TrustedCa{
+			Fingerprint: fingerprint,
+			NotBefore:   notBefore,
+			NotAfter:    notBefore.Add(365 * day),
+			FirstSeen:   firstSeen,
+		}
+	}
+	old := ca("old", now.Add(-300*day), now.Add(-300*day))
+
+	tests := []struct {
+		name     string
+		cas      []TrustedCa
+		selected []string
+		next     time.Time
+	}{
+		{
+			name:     "single ca",
+			cas:      []TrustedCa{old},
+			selected: []string{"old"},
+			next:     old.NotAfter,
+		},
+		{
+			name:     "before the overlap the new ca is trusted ahead of the rotation",
+			cas:      []TrustedCa{old, ca("new", now.Add(day), now.Add(-day))},
+			selected: []string{"old", "new"},
+			next:     now.Add(day),
+		},
+		{
+			name:     "during the overlap both cas are trusted",
+			cas:      []TrustedCa{old, ca("new", now.Add(-day), now.Add(-2*day))},
+			selected: []string{"old", "new"},
+			next:     now.Add(-day).Add(overlap),
+		},
+		{
+			name:     "after the overlap the old ca is retired",
+			cas:      []TrustedCa{old, ca("new", now.Add(-4*day), now.Add(-4*day))},
+			selected: []string{"new"},
+			next:     old.NotAfter,
+		},
+		{
+			name:     "the overlap starts when a ca published after it was issued is first seen",
+			cas:      []TrustedCa{old, ca("new", now.Add(-30*day), now.Add(-day))},
+			selected: []string{"old", "new"},
+			next:     now.Add(-day).Add(overlap),
+		},
+		{
+			name:     "the overlap starts when the ca becomes valid when it was seen ahead of time",
+			cas:      []TrustedCa{old, ca("new", now.Add(-day), now.Add(-10*day))},
+			selected: []string{"old", "new"},
+			next:     now.Add(-day).Add(overlap),
+		},
+		{
+			name:     "a ca without a first seen time starts the overlap when it becomes valid",
+			cas:      []TrustedCa{old, ca("new", now.Add(-4*day), time.Time{})},
+			selected: []string{"new"},
+			next:     old.NotAfter,
+		},
+		{
+			name:     "expired cas are dropped",
+			cas:      []TrustedCa{ca("expired", now.Add(-400*day), now.Add(-400*day)), old},
+			selected: []string{"old"},
+			next:     old.NotAfter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, next := SelectTrustedCas(tt.cas, now, overlap)
+
+			fingerprints := []string{}
+			for _, ca := range selected {
+				fingerprints = append(fingerprints, ca.Fingerprint)
+			}
+			if !reflect.DeepEqual(fingerprints, tt.selected) {
+				t.Errorf("selected %v, want %v", fingerprints, tt.selected)
+			}
+			if !next.Equal(tt.next) {
+				t.Errorf("next change at %v, want %v", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestCaFirstSeen(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	seen := now.Add(-time.Hour)
+
+	cas := WithFirstSeen([]TrustedCa{{Fingerprint: "a"}, {Fingerprint: "b"}}, map[string]time.Time{"a": seen}, now)
+	if !cas[0].FirstSeen.Equal(seen) || !cas[1].FirstSeen.Equal(now) {
+		t.Fatalf("first seen %v and %v, want %v and %v", cas[0].FirstSeen, cas[1].FirstSeen, seen, now)
+	}
+
+	firstSeen := ParseCaFirstSeen(FormatCaFirstSeen(cas))
+	if len(firstSeen) != 2 || !firstSeen["a"].Equal(seen) || !firstSeen["b"].Equal(now) {
+		t.Fatalf("round trip of the annotation gave %v", firstSeen)
+	}
+
+	if firstSeen := ParseCaFirstSeen("not json"); len(firstSeen) != 0 {
+		t.Fatalf("invalid annotation gave %v, want empty", firstSeen)
+	}
+}
@@ -0,0 +1,193 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// the egress types that are accepted for the qpoint.io/egress label
+var validEgressTypes = []EgressType{
+	EgressType_DISABLE,
+	EgressType_SERVICE,
+	EgressType_INJECT,
+	EgressType_PROXY_ENV,
+}
+
+type Validator struct {
+	Decoder   *admission.Decoder
+	Overrides *OverridePolicy
+}
+
+// +kubebuilder:webhook:path=/validate-v1-pod,mutating=false,failurePolicy=ignore,groups="",resources=pods,verbs=create;update,versions=v1,name=vpod.kb.io,sideEffects=None,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-v1-namespace,mutating=false,failurePolicy=ignore,groups="",resources=namespaces,verbs=create;update,versions=v1,name=vnamespace.kb.io,sideEffects=None,admissionReviewVersions=v1
+
+func (v *Validator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// create a logger
+	validatorLog := ctrl.Log.WithName(fmt.Sprintf("%s.v1.validation.webhook[%s]", strings.ToLower(req.Kind.Kind), req.UID))
+
+	var labels, annotations, oldLabels, oldAnnotations map[string]string
+	var kind, name string
+	reserved := field.ErrorList{}
+
+	switch req.Kind.Kind {
+	case "Pod":
+		pod := &corev1.Pod{}
+		if err := v.Decoder.Decode(req, pod); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		labels, annotations, kind, name = pod.Labels, pod.Annotations, "Pod", pod.Name
+
+		// the marker is only left by the operator next to the containers it injected
+		if IsMarkedMutated(pod) && !IsMutated(pod) {
+			reserved = append(reserved, field.Forbidden(field.NewPath("metadata", "annotations").Key(MUTATED_ANNOTATION), "the annotation is reserved for the operator"))
+		}
+
+		if len(req.OldObject.Raw) > 0 {
+			oldPod := &corev1.Pod{}
+			if err := v.Decoder.DecodeRaw(req.OldObject, oldPod); err != nil {
+				return admission.Errored(http.StatusBadRequest, err)
+			}
+			oldLabels, oldAnnotations = oldPod.Labels, oldPod.Annotations
+		}
+	case "Namespace":
+		namespace := &corev1.Namespace{}
+		if err := v.Decoder.Decode(req, namespace); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		labels, annotations, kind, name = namespace.Labels, namespace.Annotations, "Namespace", namespace.Name
+
+		if len(req.OldObject.Raw) > 0 {
+			oldNamespace := &corev1.Namespace{}
+			if err := v.Decoder.DecodeRaw(req.OldObject, oldNamespace); err != nil {
+				return admission.Errored(http.StatusBadRequest, err)
+			}
+			oldLabels, oldAnnotations = oldNamespace.Labels, oldNamespace.Annotations
+		}
+	default:
+		return admission.Allowed("")
+	}
+
+	// objects that existed before their qpoint settings were invalid should not be blocked from unrelated
+	// updates, so only validate when the qpoint settings changed
+	if req.Operation == admissionv1.Update && qpointKeysEqual(labels, oldLabels) && qpointKeysEqual(annotations, oldAnnotations) {
+		return admission.Allowed("")
+	}
+
+	errs, warnings := ValidateQpointKeys(labels, annotations)
+	errs = append(errs, reserved...)
+
+	// pods are checked by the mutating webhook which knows the defaults they would override, while namespaces
+	// are only checked for the annotations that changed
+	if kind == "Namespace" {
+		errs = append(errs, v.Overrides.Check(kind, annotations, oldAnnotations)...)
+	}
+	if len(errs) > 0 {
+		validatorLog.Info("Invalid qpoint configuration, denying...", "name", name, "errors", errs.ToAggregate().Error())
+		return admission.Denied(fmt.Sprintf("%s %q has invalid qpoint configuration: %s", kind, name, errs.ToAggregate().Error())).
+			WithWarnings(warnings...)
+	}
+
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+// ValidateQpointKeys validates the qpoint.io labels and annotations of an object. Invalid values are returned
+// as errors while unknown annotations are returned as warnings.
+func ValidateQpointKeys(labels map[string]string, annotations map[string]string) (field.ErrorList, []string) {
+	errs := field.ErrorList{}
+	warnings := []string{}
+
+	// labels
+	labelsPath := field.NewPath("metadata", "labels")
+	for _, key := range sortedKeys(labels) {
+		value := labels[key]
+
+		if key == NAMESPACE_EGRESS_ENFORCED_LABEL {
+			if value != "true" && value != "false" {
+				errs = append(errs, field.NotSupported(labelsPath.Key(key), value, []string{"true", "false"}))
+			}
+			continue
+		}
+
+		if key != POD_EGRESS_LABEL {
+			continue
+		}
+
+		valid := false
+		allowed := []string{}
+		for _, egressType := range validEgressTypes {
+			valid = valid || EgressType(value) == egressType
+			allowed = append(allowed, string(egressType))
+		}
+		if !valid {
+			errs = append(errs, field.NotSupported(labelsPath.Key(key), value, allowed))
+		}
+	}
+
+	// annotations
+	annotationsPath := field.NewPath("metadata", "annotations")
+	for _, key := range sortedKeys(annotations) {
+		if !strings.HasPrefix(key, ANNOTATION_PREFIX) {
+			continue
+		}
+		value := annotations[key]
+
+		// annotations left by the operator itself
+		if key == MUTATED_ANNOTATION || key == JOB_SIDECAR_ANNOTATION || key == JOB_SIDECAR_SHUTDOWN_ANNOTATION {
+			continue
+		}
+
+		annotation := LookupAnnotation(key)
+		if annotation == nil {
+			warnings = append(warnings, fmt.Sprintf("annotation %s is not a known qpoint annotation and is ignored", key))
+			continue
+		}
+
+		// an empty value is treated as not set
+		if value == "" {
+			continue
+		}
+
+		if _, err := annotation.Parse(value); err != nil {
+			errs = append(errs, field.Invalid(annotationsPath.Key(key), value, err.Error()))
+		}
+	}
+
+	return errs, warnings
+}
+
+// qpointKeysEqual compares only the qpoint keys of two label or annotation maps
+func qpointKeysEqual(a map[string]string, b map[string]string) bool {
+	isQpointKey := func(key string) bool {
+		return strings.HasPrefix(key, ANNOTATION_PREFIX)
+	}
+
+	for key, value := range a {
+		if isQpointKey(key) && b[key] != value {
+			return false
+		}
+	}
+	for key, value := range b {
+		if isQpointKey(key) && a[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,20 +3,30 @@ package v1
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 type Webhook struct {
-	Namespace   string
-	ApiClient   client.Client
-	Decoder     *admission.Decoder
-	Development bool
+	Namespace      string
+	ApiClient      client.Client
+	Decoder        *admission.Decoder
+	Overrides      *OverridePolicy
+	NativeSidecars bool
+	// InitRepository and QtapRepository are the image repositories used unless the policy sets another
+	InitRepository   string
+	QtapRepository   string
+	ImagePullSecrets []string
+	Development      bool
 }
 
 // +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod.kb.io,sideEffects=None,admissionReviewVersions=v1
@@ -33,6 +43,25 @@ func (w *Webhook) Handle(ctx context.Context, req admission.Request) admission.R
 
 	webhookLog.Info("Pod mutation requested")
 
+	// the mutation only changes the pod in the request and has no side effects (the CA bundle in the namespace
+	// is maintained by the namespace controller), so dry-run requests are mutated the same as any other
+	if req.DryRun != nil && *req.DryRun {
+		webhookLog.Info("Pod mutation requested for a dry-run")
+	}
+
+	// containers and volumes can't be added to an existing pod, so only pod creation is mutated
+	if req.Operation != admissionv1.Create {
+		webhookLog.Info("Pod mutation is only performed on create, ignoring...", "operation", req.Operation)
+		return admission.Allowed("")
+	}
+
+	// the webhook can be invoked again for the same pod (reinvocation or other webhooks changing the pod). The
+	// marker is as easily set by the pod itself, so it skips nothing: the pod is checked and mutated again, which
+	// replaces the injected containers in place and leaves a pod that was already mutated unchanged.
+	if IsMarkedMutated(pod) {
+		webhookLog.Info("Pod is marked as mutated, mutating again...")
+	}
+
 	// initialize a config with defaults
 	config := &Config{
 		EgressType:        EgressType_UNDEFINED,
@@ -41,14 +70,33 @@ func (w *Webhook) Handle(ctx context.Context, req admission.Request) admission.R
 		InjectCa:          false,
 		Client:            w.ApiClient,
 		Ctx:               ctx,
+		Overrides:         w.Overrides,
+		NativeSidecars:    w.NativeSidecars,
+		InitRepository:    w.InitRepository,
+		QtapRepository:    w.QtapRepository,
+		ImagePullSecrets:  w.ImagePullSecrets,
 	}
 
 	// initialize config for this pod
 	if err := config.Init(pod); err != nil {
+		if apierrors.IsInvalid(err) {
+			webhookLog.Info("Pod has invalid or blocked qpoint annotations, denying...", "error", err.Error())
+			return admission.Denied(err.Error())
+		}
+		var podSecurity *PodSecurityError
+		if errors.As(err, &podSecurity) {
+			webhookLog.Info("Pod would violate the pod security standard of the namespace, denying...", "error", err.Error())
+			return admission.Denied(err.Error()).WithWarnings(config.Warnings...)
+		}
 		webhookLog.Error(err, "failed to initialize config for pod")
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
+	// the markers are only left on the pods mutated below
+	delete(pod.Annotations, MUTATED_ANNOTATION)
+	delete(pod.Annotations, JOB_SIDECAR_ANNOTATION)
+	delete(pod.Annotations, JOB_SIDECAR_SHUTDOWN_ANNOTATION)
+
 	switch v := config.EgressType; EgressType(v) {
 	case EgressType_SERVICE:
 		// for this case the pod is mutated for service egress
@@ -61,17 +109,12 @@ func (w *Webhook) Handle(ctx context.Context, req admission.Request) admission.R
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
 
-		if config.InjectCa {
-			if err := EnsureAssetsInNamespace(config); err != nil {
-				webhookLog.Error(err, "failed to add assets to namespace for ca injection")
-				return admission.Errored(http.StatusInternalServerError, err)
-			}
-
-			if err := MutateCaInjection(pod, config); err != nil {
-				webhookLog.Error(err, "failed to mutate pod for ca injection")
-				return admission.Errored(http.StatusInternalServerError, err)
-			}
+		// mutate the pod to trust the Qpoint CA
+		if err := MutateCa(pod, config); err != nil {
+			return caErrorResponse(webhookLog, err)
 		}
+
+		MarkMutated(pod, config.EgressType)
 	case EgressType_INJECT:
 		// for this case the pod is mutated for sidecar egress
 
@@ -89,17 +132,37 @@ func (w *Webhook) Handle(ctx context.Context, req admission.Request) admission.R
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
 
-		if config.InjectCa {
-			if err := EnsureAssetsInNamespace(config); err != nil {
-				webhookLog.Error(err, "failed to add assets to namespace for ca injection")
-				return admission.Errored(http.StatusInternalServerError, err)
-			}
+		// mutate the pod to trust the Qpoint CA
+		if err := MutateCa(pod, config); err != nil {
+			return caErrorResponse(webhookLog, err)
+		}
+
+		MarkMutated(pod, config.EgressType)
+	case EgressType_PROXY_ENV:
+		// for this case the pod is mutated for egress through the proxy environment variables
 
-			if err := MutateCaInjection(pod, config); err != nil {
-				webhookLog.Error(err, "failed to mutate pod for ca injection")
+		webhookLog.Info("Qpoint egress with proxy environment variables enabled, mutating...", "target", config.Settings.ProxyEnvTarget)
+
+		// mutate the pod to include the sidecar the variables point to
+		if config.Settings.ProxyEnvTarget == ProxyEnvTarget_SIDECAR {
+			if err := MutateInjection(pod, config); err != nil {
+				webhookLog.Error(err, "failed to mutate pod for injection")
 				return admission.Errored(http.StatusInternalServerError, err)
 			}
 		}
+
+		// mutate the pod to include the proxy environment variables
+		if err := MutateProxyEnv(pod, config); err != nil {
+			webhookLog.Error(err, "failed to mutate pod for proxy egress")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+
+		// mutate the pod to trust the Qpoint CA
+		if err := MutateCa(pod, config); err != nil {
+			return caErrorResponse(webhookLog, err)
+		}
+
+		MarkMutated(pod, config.EgressType)
 	case EgressType_DISABLE:
 		webhookLog.Info("Qpoint egress disabled, ignoring...")
 	default:
@@ -111,5 +174,17 @@ func (w *Webhook) Handle(ctx context.Context, req admission.Request) admission.R
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod).WithWarnings(config.Warnings...)
+}
+
+// caErrorResponse is the response when the CA injection fails. A conflicting volume mount is caused by the pod
+// itself and denies it, anything else is an error of the webhook.
+func caErrorResponse(webhookLog logr.Logger, err error) admission.Response {
+	var conflict *MountConflictError
+	if errors.As(err, &conflict) {
+		webhookLog.Info("Pod has a conflicting volume mount, denying...", "error", err.Error())
+		return admission.Denied(err.Error())
+	}
+	webhookLog.Error(err, "failed to mutate pod for ca injection")
+	return admission.Errored(http.StatusInternalServerError, err)
 }
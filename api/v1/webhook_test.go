@@ -0,0 +1,328 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+const testNamespace = "apps"
+const testOperatorNamespace = "qpoint"
+
+// the defaults of the annotation configmaps the tests admit pods with
+var testInjectAnnotations = map[string]string{
+	"qpoint.io/inject-ca":                     "true",
+	"qpoint.io/qtap-init-egress-port-mapping": "10080:80,10443:443",
+	"qpoint.io/qtap-init-egress-accept-uids":  "1010",
+	"qpoint.io/qtap-init-egress-accept-gids":  "1010",
+	"qpoint.io/qtap-uid":                      "1010",
+	"qpoint.io/qtap-gid":                      "1010",
+	"qpoint.io/qtap-egress-http-listen":       "0.0.0.0:10080",
+	"qpoint.io/qtap-egress-https-listen":      "0.0.0.0:10443",
+	"qpoint.io/qtap-labels-tags-filter":       "app,.*name$",
+}
+var testProxyEnvAnnotations = map[string]string{
+	"qpoint.io/proxy-env-target":              "sidecar",
+	"qpoint.io/qtap-init-egress-to-domain":    "qtap-gateway.qpoint.svc.cluster.local",
+	"qpoint.io/qtap-init-egress-port-mapping": "10080:80,10443:443",
+	"qpoint.io/qtap-uid":                      "1010",
+	"qpoint.io/qtap-egress-http-listen":       "0.0.0.0:10080",
+}
+
+func annotationsConfigMap(t *testing.T, name string, annotations map[string]string) *corev1.ConfigMap {
+	t.Helper()
+	data, err := yaml.Marshal(annotations)
+	if err != nil {
+		t.Fatalf("marshaling annotations: %v", err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testOperatorNamespace},
+		Data:       map[string]string{"annotations.yaml": string(data)},
+	}
+}
+
+func testNamespaceWith(labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace, Labels: labels}}
+}
+
+// newTestWebhook creates a webhook with the default override policy, reading the objects from a fake client
+func newTestWebhook(t *testing.T, objects ...client.Object) *Webhook {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	objects = append(objects,
+		annotationsConfigMap(t, SERVICE_ANNOTATIONS_CONFIGMAP, testInjectAnnotations),
+		annotationsConfigMap(t, INJECT_ANNOTATIONS_CONFIGMAP, testInjectAnnotations),
+		annotationsConfigMap(t, PROXY_ENV_ANNOTATIONS_CONFIGMAP, testProxyEnvAnnotations),
+	)
+
+	overrides, err := NewOverridePolicy(nil, DefaultDeniedOverrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Webhook{
+		Namespace: testOperatorNamespace,
+		ApiClient: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Decoder:   admission.NewDecoder(scheme),
+		Overrides: overrides,
+	}
+}
+
+func admitPod(t *testing.T, w *Webhook, pod *corev1.Pod) admission.Response {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UID:       "test",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Operation: admissionv1.Create,
+		Namespace: testNamespace,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+}
+
+// patchedPod applies the patches of an allowed response to the pod
+func patchedPod(t *testing.T, pod *corev1.Pod, response admission.Response) *corev1.Pod {
+	t.Helper()
+	if !response.Allowed {
+		t.Fatalf("pod denied: %v", response.Result.Message)
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	operations, err := json.Marshal(response.Patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := jsonpatch.DecodePatch(operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutated := &corev1.Pod{}
+	if err := json.Unmarshal(patched, mutated); err != nil {
+		t.Fatal(err)
+	}
+	return mutated
+}
+
+func testPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   testNamespace,
+			Labels:      map[string]string{"app": "app"},
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "app:latest"}},
+		},
+	}
+}
+
+func findContainer(containers []corev1.Container, name string) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+func TestWebhookReadmission(t *testing.T) {
+	w := newTestWebhook(t, testNamespaceWith(map[string]string{NAMESPACE_EGRESS_LABEL: "inject"}))
+
+	mutated := patchedPod(t, testPod(nil), admitPod(t, w, testPod(nil)))
+	if mutated.Annotations[MUTATED_ANNOTATION] != string(EgressType_INJECT) {
+		t.Fatalf("marker %q, want %q", mutated.Annotations[MUTATED_ANNOTATION], EgressType_INJECT)
+	}
+	if findContainer(mutated.Spec.InitContainers, "qtap-init") == nil || findContainer(mutated.Spec.Containers, "qtap") == nil {
+		t.Fatalf("pod lacks the injected containers")
+	}
+
+	// a pod that was already mutated is left as is
+	response := admitPod(t, w, mutated)
+	if !response.Allowed || len(response.Patches) > 0 {
+		t.Fatalf("readmission allowed %v with patches %v, want no patches", response.Allowed, response.Patches)
+	}
+}
+
+func TestWebhookForgedMarker(t *testing.T) {
+	enforcedInject := map[string]string{NAMESPACE_EGRESS_LABEL: "inject", NAMESPACE_EGRESS_ENFORCED_LABEL: "true"}
+
+	tests := []struct {
+		name   string
+		forged func() *corev1.Pod
+	}{
+		{
+			name: "the service marker next to an init container of the pod named qtap-init",
+			forged: func() *corev1.Pod {
+				pod := testPod(map[string]string{MUTATED_ANNOTATION: string(EgressType_SERVICE)})
+				pod.Spec.InitContainers = []corev1.Container{{Name: "qtap-init", Image: "busybox", Command: []string{"true"}}}
+				return pod
+			},
+		},
+		{
+			name: "the proxy-env marker next to proxy variables the pod sets itself",
+			forged: func() *corev1.Pod {
+				pod := testPod(map[string]string{
+					MUTATED_ANNOTATION:           string(EgressType_PROXY_ENV),
+					"qpoint.io/proxy-env-target": string(ProxyEnvTarget_SERVICE),
+				})
+				pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "HTTP_PROXY", Value: ""}}
+				return pod
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWebhook(t, testNamespaceWith(enforcedInject))
+
+			forged := tt.forged()
+			response := admitPod(t, w, forged)
+			if len(response.Patches) == 0 {
+				t.Fatalf("forged pod allowed %v without patches", response.Allowed)
+			}
+
+			// the pod is mutated for the egress of the namespace regardless of the marker
+			mutated := patchedPod(t, forged, response)
+			if mutated.Annotations[MUTATED_ANNOTATION] != string(EgressType_INJECT) {
+				t.Errorf("marker %q, want %q", mutated.Annotations[MUTATED_ANNOTATION], EgressType_INJECT)
+			}
+			initContainer := findContainer(mutated.Spec.InitContainers, "qtap-init")
+			if initContainer == nil || initContainer.Image == "busybox" || len(initContainer.Command) > 0 {
+				t.Errorf("qtap-init %v, want the injected container", initContainer)
+			}
+			if findContainer(mutated.Spec.Containers, "qtap") == nil {
+				t.Errorf("pod lacks the qtap container")
+			}
+		})
+	}
+}
+
+func TestWebhookReservedContainers(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace map[string]string
+		pod       func() *corev1.Pod
+		allowed   bool
+	}{
+		{
+			name:      "qtap-ca-init is only injected in the merge mode",
+			namespace: map[string]string{NAMESPACE_EGRESS_LABEL: "inject"},
+			pod: func() *corev1.Pod {
+				pod := testPod(nil)
+				pod.Spec.InitContainers = []corev1.Container{{Name: "qtap-ca-init", Image: "busybox"}}
+				return pod
+			},
+		},
+		{
+			name:      "qtap is not an init container without native sidecars",
+			namespace: map[string]string{NAMESPACE_EGRESS_LABEL: "inject"},
+			pod: func() *corev1.Pod {
+				pod := testPod(nil)
+				pod.Spec.InitContainers = []corev1.Container{{Name: "qtap", Image: "busybox"}}
+				return pod
+			},
+		},
+		{
+			name:      "qtap-init is not injected in the proxy-env mode",
+			namespace: map[string]string{NAMESPACE_EGRESS_LABEL: "proxy-env"},
+			pod: func() *corev1.Pod {
+				pod := testPod(nil)
+				pod.Spec.InitContainers = []corev1.Container{{Name: "qtap-init", Image: "busybox"}}
+				return pod
+			},
+		},
+		{
+			name:      "the names are free to use without egress",
+			namespace: nil,
+			pod: func() *corev1.Pod {
+				pod := testPod(map[string]string{MUTATED_ANNOTATION: string(EgressType_INJECT)})
+				pod.Spec.InitContainers = []corev1.Container{{Name: "qtap-init", Image: "busybox"}}
+				return pod
+			},
+			allowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWebhook(t, testNamespaceWith(tt.namespace))
+
+			pod := tt.pod()
+			response := admitPod(t, w, pod)
+			if response.Allowed != tt.allowed {
+				t.Fatalf("allowed %v, want %v: %v", response.Allowed, tt.allowed, response.Result.Message)
+			}
+
+			// a marker the pod set itself is dropped when it isn't mutated
+			if tt.allowed {
+				if _, marked := patchedPod(t, pod, response).Annotations[MUTATED_ANNOTATION]; marked {
+					t.Errorf("the marker of the pod was kept")
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookJobShutdownFile(t *testing.T) {
+	for _, native := range []bool{false, true} {
+		t.Run(fmt.Sprintf("native sidecars %v", native), func(t *testing.T) {
+			w := newTestWebhook(t, testNamespaceWith(map[string]string{NAMESPACE_EGRESS_LABEL: "inject"}))
+			w.NativeSidecars = native
+
+			pod := testPod(map[string]string{JOB_SIDECAR_SHUTDOWN_ANNOTATION: "true"})
+			pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "job", UID: "job"}}
+			mutated := patchedPod(t, pod, admitPod(t, w, pod))
+
+			// a shutdown annotation the pod sets itself is dropped
+			if _, set := mutated.Annotations[JOB_SIDECAR_SHUTDOWN_ANNOTATION]; set {
+				t.Errorf("the shutdown annotation of the pod was kept")
+			}
+
+			qtap := findContainer(append(mutated.Spec.InitContainers, mutated.Spec.Containers...), "qtap")
+			if qtap == nil {
+				t.Fatalf("pod lacks the qtap container")
+			}
+			mounted := false
+			for _, mount := range qtap.VolumeMounts {
+				mounted = mounted || mount.Name == QTAP_LIFECYCLE_VOLUME
+			}
+			if mounted == native || hasEnvValue(qtap.Env, "SHUTDOWN_FILE", QTAP_LIFECYCLE_DIR+"/"+QTAP_SHUTDOWN_FILE) == native {
+				t.Errorf("qtap mounts the shutdown file %v, want %v", mounted, !native)
+			}
+			if _, marked := mutated.Annotations[JOB_SIDECAR_ANNOTATION]; marked == native {
+				t.Errorf("job sidecar marker %v, want %v", marked, !native)
+			}
+		})
+	}
+}
@@ -20,15 +20,21 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -36,6 +42,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+	"github.com/qpoint-io/kubernetes-qtap-operator/internal/controller"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -46,6 +53,7 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(qtapv1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
 }
@@ -54,11 +62,51 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var caRefreshInterval time.Duration
+	var caOverlapPeriod time.Duration
+	var caRolloutRestart bool
+	var baseCaBundlesConfigMap string
+	var baseCaBundlesDir string
+	var extraCaSources string
+	var overrideAllow string
+	var overrideDeny string
+	var nativeSidecars string
+	var initImageRepository string
+	var qtapImageRepository string
+	var imagePullSecrets string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&caRefreshInterval, "ca-refresh-interval", time.Hour,
+		"How often the Qpoint CA is fetched from the registration API and the namespace CA bundles are rebuilt.")
+	flag.DurationVar(&caOverlapPeriod, "ca-overlap-period", 72*time.Hour,
+		"How long a replaced Qpoint CA remains trusted after the CA replacing it becomes valid.")
+	flag.BoolVar(&caRolloutRestart, "ca-rollout-restart", false,
+		"Restart the deployments, statefulsets and daemonsets with pods mounting a CA bundle when the Qpoint CA changes.")
+	flag.StringVar(&baseCaBundlesConfigMap, "base-ca-bundles-configmap", "",
+		"The configmap in the operator namespace with the base CA bundles the Qpoint CA is appended to.")
+	flag.StringVar(&baseCaBundlesDir, "base-ca-bundles-dir", "",
+		"The directory with the base CA bundles the Qpoint CA is appended to. The configmap takes precedence.")
+	flag.StringVar(&extraCaSources, "extra-ca-sources", "",
+		"A comma separated list of <configmap|secret>/<name>[/<key>] in the operator namespace with additional CAs "+
+			"to add to the CA bundles of every namespace.")
+	flag.StringVar(&overrideAllow, "override-allow", "",
+		"A comma separated list of the qpoint.io annotations pods and namespaces may override. When set, every "+
+			"other annotation is blocked.")
+	flag.StringVar(&overrideDeny, "override-deny", strings.Join(qtapv1.DefaultDeniedOverrides, ","),
+		"A comma separated list of the qpoint.io annotations pods and namespaces may not override.")
+	flag.StringVar(&nativeSidecars, "native-sidecars", string(qtapv1.NativeSidecars_AUTO),
+		"Inject qtap as a native sidecar (an init container with restartPolicy Always): auto, true or false. "+
+			"In auto mode native sidecars are used when the API server is at least 1.29.")
+	flag.StringVar(&initImageRepository, "init-image-repository", qtapv1.INIT_IMAGE,
+		"The repository of the qtap-init image, unless a policy sets another.")
+	flag.StringVar(&qtapImageRepository, "qtap-image-repository", qtapv1.QTAP_IMAGE,
+		"The repository of the qtap image, unless a policy sets another.")
+	flag.StringVar(&imagePullSecrets, "image-pull-secrets", "",
+		"A comma separated list of image pull secrets in the operator namespace which are synced into every "+
+			"namespace with egress enabled and added to the mutated pods.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -74,8 +122,29 @@ func main() {
 		os.Exit(1)
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	restConfig := ctrl.GetConfigOrDie()
+
+	// only the secrets of the operator namespace are cached, and the configmaps of the operator namespace along
+	// with the ones the operator manages elsewhere. The copies and extra CA sources in other namespaces are read
+	// from the api.
+	managed := labels.SelectorFromSet(labels.Set{controller.MANAGED_BY_LABEL: controller.MANAGED_BY})
+	cacheOptions := cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {
+				Namespaces: map[string]cache.Config{string(namespace): {}},
+			},
+			&corev1.ConfigMap{}: {
+				Namespaces: map[string]cache.Config{
+					string(namespace):   {},
+					cache.AllNamespaces: {LabelSelector: managed},
+				},
+			},
+		},
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme:                 scheme,
+		Cache:                  cacheOptions,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -97,16 +166,100 @@ func main() {
 		os.Exit(1)
 	}
 
+	// additional CAs for every namespace
+	clusterExtraCaSources, err := qtapv1.ParseExtraCaSources(extraCaSources, string(namespace))
+	if err != nil {
+		setupLog.Error(err, "invalid extra CA sources")
+		os.Exit(1)
+	}
+
+	// the annotations pods and namespaces may override
+	overrides, err := parseOverridePolicy(overrideAllow, overrideDeny)
+	if err != nil {
+		setupLog.Error(err, "invalid override policy")
+		os.Exit(1)
+	}
+
+	// the image pull secrets for the qtap images
+	pullSecrets := []string{}
+	for _, name := range strings.Split(imagePullSecrets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			pullSecrets = append(pullSecrets, name)
+		}
+	}
+
+	// inject qtap as a native sidecar when the cluster supports it
+	useNativeSidecars, err := qtapv1.ResolveNativeSidecars(qtapv1.NativeSidecars(nativeSidecars), restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to determine native sidecar support")
+		os.Exit(1)
+	}
+	setupLog.Info("qtap sidecar injection", "nativeSidecars", useNativeSidecars)
+
+	// maintain the assets mutated pods depend on in the namespaces with egress enabled
+	if err = (&controller.NamespaceReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		APIReader:         mgr.GetAPIReader(),
+		OperatorNamespace: string(namespace),
+		CaSource: &qtapv1.CaSource{
+			Client:            mgr.GetClient(),
+			OperatorNamespace: string(namespace),
+			RefreshInterval:   caRefreshInterval,
+		},
+		BaseBundles: &qtapv1.BaseBundleSource{
+			Client:            mgr.GetClient(),
+			OperatorNamespace: string(namespace),
+			ConfigMapName:     baseCaBundlesConfigMap,
+			Dir:               baseCaBundlesDir,
+		},
+		ExtraCaSources:   clusterExtraCaSources,
+		ImagePullSecrets: pullSecrets,
+		RefreshInterval:  caRefreshInterval,
+		OverlapPeriod:    caOverlapPeriod,
+		RolloutRestart:   caRolloutRestart,
+		Overrides:        overrides,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Namespace")
+		os.Exit(1)
+	}
+
+	// stop the qtap sidecar of Job pods once the application completes, which native sidecars do on their own
+	if !useNativeSidecars {
+		if err = (&controller.JobSidecarReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "JobSidecar")
+			os.Exit(1)
+		}
+	}
+
 	// register admission webhook for pods
 	mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{
 		Handler: &qtapv1.Webhook{
-			Namespace:   string(namespace),
-			ApiClient:   mgr.GetClient(),
-			Decoder:     admission.NewDecoder(mgr.GetScheme()),
-			Development: true,
+			Namespace:        string(namespace),
+			ApiClient:        mgr.GetClient(),
+			Decoder:          admission.NewDecoder(mgr.GetScheme()),
+			Overrides:        overrides,
+			NativeSidecars:   useNativeSidecars,
+			InitRepository:   initImageRepository,
+			QtapRepository:   qtapImageRepository,
+			ImagePullSecrets: pullSecrets,
+			Development:      true,
 		},
 	})
 
+	// register validation webhooks for the qpoint labels and annotations of pods and namespaces
+	validator := &webhook.Admission{
+		Handler: &qtapv1.Validator{
+			Decoder:   admission.NewDecoder(mgr.GetScheme()),
+			Overrides: overrides,
+		},
+	}
+	mgr.GetWebhookServer().Register("/validate-v1-pod", validator)
+	mgr.GetWebhookServer().Register("/validate-v1-namespace", validator)
+
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -124,3 +277,9 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// parseOverridePolicy creates the override policy from the comma separated --override-allow and --override-deny
+// flags
+func parseOverridePolicy(allow string, deny string) (*qtapv1.OverridePolicy, error) {
+	return qtapv1.NewOverridePolicy(strings.Split(allow, ","), strings.Split(deny, ","))
+}
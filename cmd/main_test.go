@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+func TestParseOverridePolicy(t *testing.T) {
+	defaultDeny := strings.Join(qtapv1.DefaultDeniedOverrides, ",")
+
+	tests := []struct {
+		name    string
+		allow   string
+		deny    string
+		blocked map[string]bool
+		invalid bool
+	}{
+		{
+			name: "the default flags",
+			deny: defaultDeny,
+			blocked: map[string]bool{
+				"qpoint.io/token-secret":                  true,
+				"qpoint.io/qtap-init-egress-port-mapping": true,
+				"qpoint.io/proxy-env-no-proxy":            true,
+				"qpoint.io/qtap-log-level":                false,
+			},
+		},
+		{
+			name: "an empty deny flag blocks nothing",
+			blocked: map[string]bool{
+				"qpoint.io/token-secret": false,
+			},
+		},
+		{
+			name:  "names with and without the prefix and spaces",
+			allow: "qtap-log-level, qpoint.io/token-secret,",
+			deny:  "qpoint.io/token-secret",
+			blocked: map[string]bool{
+				"qpoint.io/qtap-log-level": false,
+				"qpoint.io/token-secret":   true,
+				"qpoint.io/inject-ca":      true,
+			},
+		},
+		{
+			name:    "an unknown allowed annotation",
+			allow:   "qtap-log-level,qtap-loglevel",
+			invalid: true,
+		},
+		{
+			name:    "an unknown denied annotation",
+			deny:    defaultDeny + ",token",
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := parseOverridePolicy(tt.allow, tt.deny)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("the flags were accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, want := range tt.blocked {
+				if got := policy.Blocked(name); got != want {
+					t.Errorf("%s blocked %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
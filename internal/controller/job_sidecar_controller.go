@@ -0,0 +1,97 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+// JobSidecarReconciler stops the qtap container of pods owned by Jobs once every application container has
+// completed. Without native sidecars qtap keeps running after the application exits, so the pod (and the Job)
+// would never complete. The pod is annotated, which the kubelet writes to the shutdown file qtap watches.
+type JobSidecarReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+func (r *JobSidecarReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// the annotation can be set by anyone creating pods, only the qtap container the webhook injected into the
+	// pod of a Job is stopped
+	if !qtapv1.IsJobPod(pod) || !qtapv1.IsMutated(pod) || !qtapv1.HasSidecar(pod.Annotations) {
+		return ctrl.Result{}, nil
+	}
+
+	// qtap was already told to shut down, or has terminated on its own
+	if pod.Annotations[qtapv1.JOB_SIDECAR_SHUTDOWN_ANNOTATION] == "true" || pod.Status.Phase != corev1.PodRunning || !applicationCompleted(pod) {
+		return ctrl.Result{}, nil
+	}
+
+	logger.Info("Application containers completed, shutting down qtap", "namespace", pod.Namespace, "pod", pod.Name)
+	patch := client.MergeFrom(pod.DeepCopy())
+	pod.Annotations[qtapv1.JOB_SIDECAR_SHUTDOWN_ANNOTATION] = "true"
+	if err := r.Patch(ctx, pod, patch); err != nil {
+		// failures are retried with the backoff of the controller
+		return ctrl.Result{}, client.IgnoreNotFound(fmt.Errorf("shutting down qtap in pod '%s' at namespace '%s': %w", pod.Name, pod.Namespace, err))
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// applicationCompleted determines if every container except qtap has terminated while qtap is still running
+func applicationCompleted(pod *corev1.Pod) bool {
+	qtapRunning := false
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == "qtap" {
+			qtapRunning = status.State.Running != nil
+			continue
+		}
+		if status.State.Terminated == nil {
+			return false
+		}
+	}
+	return qtapRunning && len(pod.Status.ContainerStatuses) == len(pod.Spec.Containers)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *JobSidecarReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isJobSidecar := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetAnnotations()[qtapv1.JOB_SIDECAR_ANNOTATION] == "true"
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("jobsidecar").
+		For(&corev1.Pod{}, builder.WithPredicates(isJobSidecar)).
+		Complete(r)
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+var running = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+var terminated = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}
+
+// jobPod is a pod of a Job mutated with the qtap sidecar, with the states of the app and qtap containers
+func jobPod(app corev1.ContainerState, qtap corev1.ContainerState) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "job-abcde",
+			Namespace: "apps",
+			Annotations: map[string]string{
+				qtapv1.MUTATED_ANNOTATION:     string(qtapv1.EgressType_INJECT),
+				qtapv1.JOB_SIDECAR_ANNOTATION: "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: batchv1.SchemeGroupVersion.String(), Kind: "Job", Name: "job", UID: "job"}},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:  corev1.RestartPolicyNever,
+			InitContainers: []corev1.Container{{Name: "qtap-init"}},
+			Containers:     []corev1.Container{{Name: "qtap"}, {Name: "app"}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "qtap", State: qtap},
+				{Name: "app", State: app},
+			},
+		},
+	}
+}
+
+func newJobSidecarReconciler(t *testing.T, pod *corev1.Pod, funcs interceptor.Funcs) *JobSidecarReconciler {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return &JobSidecarReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).WithInterceptorFuncs(funcs).Build(),
+		Scheme: scheme,
+	}
+}
+
+func reconcileJobPod(t *testing.T, r *JobSidecarReconciler, pod *corev1.Pod) (ctrl.Result, error) {
+	t.Helper()
+	return r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+}
+
+func TestJobSidecarReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      func() *corev1.Pod
+		shutdown bool
+	}{
+		{
+			name:     "the application completed",
+			pod:      func() *corev1.Pod { return jobPod(terminated, running) },
+			shutdown: true,
+		},
+		{
+			name: "the application is running",
+			pod:  func() *corev1.Pod { return jobPod(running, running) },
+		},
+		{
+			name: "qtap terminated",
+			pod:  func() *corev1.Pod { return jobPod(terminated, terminated) },
+		},
+		{
+			name: "the pod has completed",
+			pod: func() *corev1.Pod {
+				pod := jobPod(terminated, running)
+				pod.Status.Phase = corev1.PodSucceeded
+				return pod
+			},
+		},
+		{
+			name: "the pod isn't owned by a Job",
+			pod: func() *corev1.Pod {
+				pod := jobPod(terminated, running)
+				pod.OwnerReferences = nil
+				return pod
+			},
+		},
+		{
+			name: "the pod is marked but lacks the injected containers",
+			pod: func() *corev1.Pod {
+				pod := jobPod(terminated, running)
+				pod.Spec.InitContainers = nil
+				return pod
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := tt.pod()
+			r := newJobSidecarReconciler(t, pod, interceptor.Funcs{})
+
+			result, err := reconcileJobPod(t, r, pod)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !result.IsZero() {
+				t.Errorf("result %v, want no requeue", result)
+			}
+
+			reconciled := &corev1.Pod{}
+			if err := r.Get(context.Background(), client.ObjectKeyFromObject(pod), reconciled); err != nil {
+				t.Fatal(err)
+			}
+			if shutdown := reconciled.Annotations[qtapv1.JOB_SIDECAR_SHUTDOWN_ANNOTATION] == "true"; shutdown != tt.shutdown {
+				t.Errorf("shutdown %v, want %v", shutdown, tt.shutdown)
+			}
+		})
+	}
+}
+
+func TestJobSidecarReconcileShutdownOnce(t *testing.T) {
+	pod := jobPod(terminated, running)
+	pod.Annotations[qtapv1.JOB_SIDECAR_SHUTDOWN_ANNOTATION] = "true"
+
+	patches := 0
+	r := newJobSidecarReconciler(t, pod, interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			patches++
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+
+	if _, err := reconcileJobPod(t, r, pod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patches > 0 {
+		t.Errorf("a pod that was already shut down was patched %d times", patches)
+	}
+}
+
+func TestJobSidecarReconcileRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{
+			name:    "a failed patch is returned to be retried with backoff",
+			err:     errors.New("connection refused"),
+			wantErr: true,
+		},
+		{
+			name: "a pod that is gone isn't retried",
+			err:  apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "job-abcde"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := jobPod(terminated, running)
+			r := newJobSidecarReconciler(t, pod, interceptor.Funcs{
+				Patch: func(context.Context, client.WithWatch, client.Object, client.Patch, ...client.PatchOption) error {
+					return tt.err
+				},
+			})
+
+			result, err := reconcileJobPod(t, r, pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want an error %v", err, tt.wantErr)
+			}
+			if !result.IsZero() {
+				t.Errorf("result %v, want no requeue beside the backoff", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+var (
+	baseBundleAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "qtap_operator_base_ca_bundle_age_seconds",
+		Help: "Seconds since the most recently issued certificate of the base CA bundle was issued",
+	}, []string{"bundle", "source"})
+
+	baseBundleCertificates = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "qtap_operator_base_ca_bundle_certificates",
+		Help: "Number of certificates in the base CA bundle",
+	}, []string{"bundle", "source"})
+
+	baseBundleExpired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "qtap_operator_base_ca_bundle_expired_certificates",
+		Help: "Number of expired certificates in the base CA bundle",
+	}, []string{"bundle", "source"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(baseBundleAge, baseBundleCertificates, baseBundleExpired)
+}
+
+// reportBaseBundles updates the metrics of the base bundles
+func reportBaseBundles(bundles []qtapv1.BaseBundle) {
+	now := time.Now()
+
+	for _, bundle := range bundles {
+		stats := qtapv1.InspectBundle(bundle.Data, now)
+
+		// the source of a bundle can change, so drop the series of the previous source
+		for _, vec := range []*prometheus.GaugeVec{baseBundleAge, baseBundleCertificates, baseBundleExpired} {
+			vec.DeletePartialMatch(prometheus.Labels{"bundle": bundle.Key})
+		}
+
+		labels := prometheus.Labels{"bundle": bundle.Key, "source": bundle.Source}
+		if !stats.NewestIssued.IsZero() {
+			baseBundleAge.With(labels).Set(now.Sub(stats.NewestIssued).Seconds())
+		}
+		baseBundleCertificates.With(labels).Set(float64(stats.Certificates))
+		baseBundleExpired.With(labels).Set(float64(stats.Expired))
+	}
+}
@@ -0,0 +1,533 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+const MANAGED_BY_LABEL = "app.kubernetes.io/managed-by"
+const MANAGED_BY = "qtap-operator"
+
+// NamespaceReconciler maintains the assets that pods mutated by the webhook depend on (such as the
+// qtap-ca-bundle.crt configmap and the qtap-token secret) in every namespace where egress is enabled
+type NamespaceReconciler struct {
+	client.Client
+	Scheme            *runtime.Scheme
+	OperatorNamespace string
+	CaSource          *qtapv1.CaSource
+	BaseBundles       *qtapv1.BaseBundleSource
+
+	// APIReader reads the objects that are not cached, which are the secrets outside the operator namespace and
+	// the configmaps the operator doesn't manage
+	APIReader client.Reader
+
+	// ExtraCaSources are appended to the bundles of every namespace, in addition to the sources listed by the
+	// qpoint.io/extra-ca-sources annotation of a namespace
+	ExtraCaSources []qtapv1.ExtraCaSource
+
+	// RefreshInterval is how often the bundles are rebuilt to pick up a rotated Qpoint CA from the API
+	RefreshInterval time.Duration
+
+	// OverlapPeriod is how long a replaced Qpoint CA remains in the bundles after the new CA becomes valid
+	OverlapPeriod time.Duration
+
+	// ImagePullSecrets are the secrets in the operator namespace synced into every namespace with egress enabled
+	// for the qtap images
+	ImagePullSecrets []string
+
+	// RolloutRestart restarts the workloads mounting a bundle when the Qpoint CA changes, as the bundles are
+	// mounted with subPath which are not refreshed by the kubelet
+	RolloutRestart bool
+
+	// Overrides determines if the token secret annotation of a namespace is honored
+	Overrides *qtapv1.OverridePolicy
+}
+
+func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, req.NamespacedName, namespace); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// nothing to maintain in a namespace that is going away
+	if !namespace.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	instrumented, err := r.isInstrumented(ctx, namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the tokens are removed once the sidecars using them are gone
+	tokenSecrets, err := r.reconcileTokens(ctx, namespace, instrumented)
+	if errors.Is(err, errSecretReplaced) {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the other assets are left in place when egress is no longer enabled as pods that were already mutated
+	// still mount them. The tokens of the remaining sidecars are checked again until they are gone, in case the
+	// deletion of a pod is missed.
+	if !instrumented {
+		if len(tokenSecrets) > 0 {
+			return ctrl.Result{RequeueAfter: r.RefreshInterval}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.reconcilePullSecrets(ctx, namespace); errors.Is(err, errSecretReplaced) {
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the CAs of every token used in the namespace are trusted as they can differ between Qpoint accounts
+	if len(tokenSecrets) == 0 {
+		tokenSecrets = []string{qtapv1.TOKEN_SECRET}
+	}
+	// the bundle records when each CA was first seen, which is when the overlap of a rotation starts
+	bundle := &corev1.ConfigMap{}
+	bundleErr := r.Get(ctx, client.ObjectKey{Namespace: namespace.Name, Name: qtapv1.QTAP_BUNDLE}, bundle)
+	if bundleErr != nil && !apierrors.IsNotFound(bundleErr) {
+		return ctrl.Result{}, fmt.Errorf("retrieving Qtap CA config map: %w", bundleErr)
+	}
+	firstSeen := qtapv1.ParseCaFirstSeen(bundle.Annotations[qtapv1.CA_FIRST_SEEN_ANNOTATION])
+
+	now := time.Now()
+	trustedCas := []qtapv1.TrustedCa{}
+	seenCas := []qtapv1.TrustedCa{}
+	var nextChange time.Time
+	for _, tokenSecret := range tokenSecrets {
+		qpointCas, err := r.CaSource.Get(ctx, tokenSecret)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("resolving Qpoint CA: %w", err)
+		}
+		qpointCas = qtapv1.WithFirstSeen(qpointCas, firstSeen, now)
+		seenCas = qtapv1.MergeTrustedCas(seenCas, qpointCas)
+
+		// during a rotation both the previous and the new CA are trusted until the overlap has passed
+		selected, next := qtapv1.SelectTrustedCas(qpointCas, now, r.OverlapPeriod)
+		if len(selected) == 0 {
+			return ctrl.Result{}, fmt.Errorf("none of the Qpoint CAs are currently valid")
+		}
+		trustedCas = qtapv1.MergeTrustedCas(trustedCas, selected)
+		if !next.IsZero() && (nextChange.IsZero() || next.Before(nextChange)) {
+			nextChange = next
+		}
+	}
+
+	baseBundles, err := r.BaseBundles.Get(ctx)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("resolving base CA bundles: %w", err)
+	}
+	reportBaseBundles(baseBundles)
+
+	extraCaSources, err := r.extraCaSources(namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	extraCas, err := qtapv1.LoadExtraCas(ctx, r.APIReader, extraCaSources)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("resolving extra CAs: %w", err)
+	}
+
+	fingerprint := qtapv1.JoinFingerprints(qtapv1.MergeTrustedCas(extraCas, trustedCas))
+	caFirstSeen := qtapv1.FormatCaFirstSeen(seenCas)
+	data := qtapv1.BuildCaBundle(baseBundles, trustedCas, extraCas)
+
+	switch {
+	case apierrors.IsNotFound(bundleErr):
+		bundle = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      qtapv1.QTAP_BUNDLE,
+				Namespace: namespace.Name,
+				Labels: map[string]string{
+					MANAGED_BY_LABEL: MANAGED_BY,
+				},
+				Annotations: map[string]string{
+					qtapv1.CA_FINGERPRINT_ANNOTATION: fingerprint,
+					qtapv1.CA_FIRST_SEEN_ANNOTATION:  caFirstSeen,
+				},
+			},
+			Data: data,
+		}
+
+		logger.Info("Creating CA bundle", "namespace", namespace.Name, "fingerprint", fingerprint)
+		if err := r.Create(ctx, bundle); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				// the cache hasn't caught up with a configmap created elsewhere, try again with the latest
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, fmt.Errorf("creating configmap for Qtap CA bundles: %w", err)
+		}
+	case !reflect.DeepEqual(bundle.Data, data) || bundle.Annotations[qtapv1.CA_FINGERPRINT_ANNOTATION] != fingerprint || bundle.Annotations[qtapv1.CA_FIRST_SEEN_ANNOTATION] != caFirstSeen:
+		previousFingerprint := bundle.Annotations[qtapv1.CA_FINGERPRINT_ANNOTATION]
+
+		bundle.Data = data
+		if bundle.Annotations == nil {
+			bundle.Annotations = make(map[string]string)
+		}
+		bundle.Annotations[qtapv1.CA_FINGERPRINT_ANNOTATION] = fingerprint
+		bundle.Annotations[qtapv1.CA_FIRST_SEEN_ANNOTATION] = caFirstSeen
+
+		logger.Info("Updating CA bundle", "namespace", namespace.Name, "fingerprint", fingerprint, "previous", previousFingerprint)
+		if err := r.Update(ctx, bundle); err != nil {
+			return ctrl.Result{}, fmt.Errorf("updating configmap for Qtap CA bundles: %w", err)
+		}
+
+		// the CA was rotated, running pods still have the previous bundle mounted. A bundle without a fingerprint
+		// was written by an operator version that didn't record it, which isn't a rotation.
+		if r.RolloutRestart && previousFingerprint != "" && previousFingerprint != fingerprint {
+			if err := r.restartWorkloads(ctx, namespace.Name); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	if err := r.reconcileJavaTrustStore(ctx, namespace, baseBundles, trustedCas, extraCas); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// check back later in case the CA in the API or the base bundles in the directory changed, or sooner when a CA is due to be added or retired
+	requeueAfter := r.RefreshInterval
+	if !nextChange.IsZero() && time.Until(nextChange) < requeueAfter {
+		requeueAfter = time.Until(nextChange) + time.Second
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// reconcileJavaTrustStore maintains the qtap-java-truststore configmap with a PKCS12 truststore of the same CAs
+// as the bundles. The truststore is only encoded again when the certificates change.
+func (r *NamespaceReconciler) reconcileJavaTrustStore(ctx context.Context, namespace *corev1.Namespace, baseBundles []qtapv1.BaseBundle, qpointCas []qtapv1.TrustedCa, extraCas []qtapv1.TrustedCa) error {
+	logger := log.FromContext(ctx)
+
+	entries, hash := qtapv1.JavaTrustStoreCerts(baseBundles, qpointCas, extraCas)
+
+	trustStore := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace.Name, Name: qtapv1.QTAP_JAVA_TRUSTSTORE}, trustStore)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("retrieving configmap for Qtap java truststore: %w", err)
+	}
+	exists := err == nil
+
+	if exists && trustStore.Annotations[qtapv1.JAVA_TRUSTSTORE_HASH_ANNOTATION] == hash && len(trustStore.BinaryData[qtapv1.JAVA_TRUSTSTORE_KEY]) > 0 {
+		return nil
+	}
+
+	data, err := qtapv1.EncodeJavaTrustStore(entries)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		trustStore = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      qtapv1.QTAP_JAVA_TRUSTSTORE,
+				Namespace: namespace.Name,
+				Labels: map[string]string{
+					MANAGED_BY_LABEL: MANAGED_BY,
+				},
+			},
+		}
+	}
+	if trustStore.Annotations == nil {
+		trustStore.Annotations = make(map[string]string)
+	}
+	trustStore.Annotations[qtapv1.JAVA_TRUSTSTORE_HASH_ANNOTATION] = hash
+	trustStore.BinaryData = map[string][]byte{qtapv1.JAVA_TRUSTSTORE_KEY: data}
+
+	if !exists {
+		logger.Info("Creating java truststore", "namespace", namespace.Name, "certificates", len(entries))
+		if err := r.Create(ctx, trustStore); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("creating configmap for Qtap java truststore: %w", err)
+		}
+		return nil
+	}
+
+	logger.Info("Updating java truststore", "namespace", namespace.Name, "certificates", len(entries))
+	if err := r.Update(ctx, trustStore); err != nil {
+		return fmt.Errorf("updating configmap for Qtap java truststore: %w", err)
+	}
+
+	return nil
+}
+
+// extraCaSources combines the cluster wide extra CA sources with the ones of the namespace
+func (r *NamespaceReconciler) extraCaSources(namespace *corev1.Namespace) ([]qtapv1.ExtraCaSource, error) {
+	sources := append([]qtapv1.ExtraCaSource{}, r.ExtraCaSources...)
+
+	if value := namespace.Annotations[qtapv1.EXTRA_CA_SOURCES_ANNOTATION]; value != "" {
+		namespaceSources, err := qtapv1.ParseExtraCaSources(value, namespace.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", qtapv1.EXTRA_CA_SOURCES_ANNOTATION, err)
+		}
+		sources = append(sources, namespaceSources...)
+	}
+
+	return sources, nil
+}
+
+// restartWorkloads triggers a rollout restart of the workloads in the namespace with pods that mount the CA
+// bundle, the same way `kubectl rollout restart` does
+func (r *NamespaceReconciler) restartWorkloads(ctx context.Context, namespace string) error {
+	logger := log.FromContext(ctx)
+
+	pods := &metav1.PartialObjectMetadataList{}
+	pods.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+	if err := r.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("listing pods at namespace '%s' from the api: %w", namespace, err)
+	}
+
+	// find the workloads that own the pods with the bundle mounted
+	workloads := map[string]client.Object{}
+	for _, pod := range pods.Items {
+		if _, mutated := pod.Annotations[qtapv1.MUTATED_ANNOTATION]; !mutated || (pod.Annotations["qpoint.io/inject-ca"] != "true" && pod.Annotations["qpoint.io/inject-ca-java"] != "true") {
+			continue
+		}
+
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil {
+			continue
+		}
+
+		var workload client.Object
+		switch owner.Kind {
+		case "ReplicaSet":
+			// pods of a deployment are owned by a replicaset
+			replicaSet := &appsv1.ReplicaSet{}
+			if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: owner.Name}, replicaSet); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return fmt.Errorf("fetching replicaset '%s' at namespace '%s' from the api: %w", owner.Name, namespace, err)
+			}
+			deployment := metav1.GetControllerOf(replicaSet)
+			if deployment == nil || deployment.Kind != "Deployment" {
+				continue
+			}
+			workload = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: deployment.Name}}
+		case "StatefulSet":
+			workload = &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: owner.Name}}
+		case "DaemonSet":
+			workload = &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: owner.Name}}
+		default:
+			continue
+		}
+
+		workloads[fmt.Sprintf("%T/%s", workload, workload.GetName())] = workload
+	}
+
+	// the restart is triggered by changing an annotation of the pod template
+	restartedAt := time.Now().Format(time.RFC3339)
+	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":%q}}}}}`, restartedAt))
+
+	for _, workload := range workloads {
+		logger.Info("Restarting workload for CA rotation", "namespace", namespace, "name", workload.GetName(), "kind", reflect.TypeOf(workload).Elem().Name())
+		if err := r.Patch(ctx, workload, client.RawPatch(types.StrategicMergePatchType, patch)); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("restarting '%s' at namespace '%s': %w", workload.GetName(), namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// isInstrumented determines if pods in the namespace are mutated, either because of the namespace label or
+// because a pod in the namespace is labelled
+func (r *NamespaceReconciler) isInstrumented(ctx context.Context, namespace *corev1.Namespace) (bool, error) {
+	switch qtapv1.EgressType(namespace.Labels[qtapv1.NAMESPACE_EGRESS_LABEL]) {
+	case qtapv1.EgressType_SERVICE, qtapv1.EgressType_INJECT, qtapv1.EgressType_PROXY_ENV:
+		return true, nil
+	}
+
+	enabled, err := labels.NewRequirement(qtapv1.POD_EGRESS_LABEL, selection.In, []string{
+		string(qtapv1.EgressType_SERVICE),
+		string(qtapv1.EgressType_INJECT),
+		string(qtapv1.EgressType_PROXY_ENV),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	pods := &metav1.PartialObjectMetadataList{}
+	pods.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+	if err := r.List(ctx, pods,
+		client.InNamespace(namespace.Name),
+		client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*enabled)},
+	); err != nil {
+		return false, fmt.Errorf("listing pods at namespace '%s' from the api: %w", namespace.Name, err)
+	}
+
+	return len(pods.Items) > 0, nil
+}
+
+// allInstrumented maps an event to every namespace that is (or was) instrumented. This is used when a change
+// affects the bundles in every namespace.
+func (r *NamespaceReconciler) allInstrumented(ctx context.Context, _ client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+
+	names := map[string]bool{}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces, client.HasLabels{qtapv1.NAMESPACE_EGRESS_LABEL}); err != nil {
+		logger.Error(err, "failed to list namespaces")
+	}
+	for _, namespace := range namespaces.Items {
+		names[namespace.Name] = true
+	}
+
+	// namespaces where only pods are labelled still have a bundle created by the operator
+	bundles := &corev1.ConfigMapList{}
+	if err := r.List(ctx, bundles, client.MatchingLabels{MANAGED_BY_LABEL: MANAGED_BY}); err != nil {
+		logger.Error(err, "failed to list CA bundles")
+	}
+	for _, bundle := range bundles.Items {
+		names[bundle.Namespace] = true
+	}
+
+	requests := make([]reconcile.Request, 0, len(names))
+	for name := range names {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: name}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NamespaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	hasEgressLabel := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		_, exists := o.GetLabels()[qtapv1.NAMESPACE_EGRESS_LABEL]
+		return exists
+	})
+
+	// the update removing the label is let through as well, so that the tokens of the namespace are removed
+	hadEgressLabel := predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			_, exists := e.ObjectOld.GetLabels()[qtapv1.NAMESPACE_EGRESS_LABEL]
+			return exists
+		},
+	}
+
+	isBundle := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetName() == qtapv1.QTAP_BUNDLE || o.GetName() == qtapv1.QTAP_JAVA_TRUSTSTORE
+	})
+
+	isQpointRootCa := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetName() == qtapv1.QPOINT_ROOT_CA && o.GetNamespace() == r.OperatorNamespace
+	})
+
+	// the secrets in the operator namespace that can be selected as a token, including the update that makes a
+	// secret no longer selectable so that its copies are removed
+	isSelectableToken := func(o client.Object) bool {
+		secret, ok := o.(*corev1.Secret)
+		return ok && secret.Namespace == r.OperatorNamespace && qtapv1.IsSelectableToken(secret)
+	}
+	isToken := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return isSelectableToken(e.Object) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return isSelectableToken(e.Object) },
+		GenericFunc: func(e event.GenericEvent) bool { return isSelectableToken(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return isSelectableToken(e.ObjectOld) || isSelectableToken(e.ObjectNew)
+		},
+	}
+
+	hasTokenSecret := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		_, exists := o.GetAnnotations()[qtapv1.TOKEN_SECRET_ANNOTATION]
+		return exists
+	})
+
+	// mutated pods of a namespace that is labelled (rather than the pods) carry neither the label nor the
+	// annotation, the token of their sidecar may be removed once they are deleted
+	mutatedPodDeleted := predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		UpdateFunc:  func(event.UpdateEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			_, exists := e.Object.GetAnnotations()[qtapv1.MUTATED_ANNOTATION]
+			return exists
+		},
+	}
+
+	isPullSecret := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		for _, name := range r.ImagePullSecrets {
+			if o.GetName() == name && o.GetNamespace() == r.OperatorNamespace {
+				return true
+			}
+		}
+		return false
+	})
+
+	// only the extra CA sources of every namespace are watched, the sources of a namespace are read again when
+	// its annotation changes and on every refresh
+	isExtraCaSource := func(kind string) predicate.Predicate {
+		return predicate.NewPredicateFuncs(func(o client.Object) bool {
+			return qtapv1.ReferencesExtraCaSource(r.ExtraCaSources, kind, o.GetNamespace(), o.GetName())
+		})
+	}
+
+	isBaseBundles := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return r.BaseBundles.ConfigMapName != "" && o.GetName() == r.BaseBundles.ConfigMapName && o.GetNamespace() == r.OperatorNamespace
+	})
+
+	// map namespaced objects to their namespace
+	toNamespace := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: o.GetNamespace()}}}
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("namespace").
+		For(&corev1.Namespace{}, builder.WithPredicates(predicate.Or(hasEgressLabel, hadEgressLabel))).
+		Watches(&corev1.ConfigMap{}, toNamespace, builder.WithPredicates(isBundle)).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.allInstrumented), builder.WithPredicates(predicate.Or(isQpointRootCa, isBaseBundles, isExtraCaSource("ConfigMap")))).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.allInstrumented), builder.WithPredicates(predicate.Or(isToken, isPullSecret, isExtraCaSource("Secret")))).
+		Watches(&corev1.Pod{}, toNamespace, builder.WithPredicates(predicate.Or(hasEgressLabel, hasTokenSecret, mutatedPodDeleted)), builder.OnlyMetadata).
+		Complete(r)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+// reconcilePullSecrets syncs the image pull secrets of the operator namespace into the namespace as
+// qtap-pull-<name>, which the webhook adds to the imagePullSecrets of mutated pods. Copies of secrets that are
+// no longer configured are removed.
+func (r *NamespaceReconciler) reconcilePullSecrets(ctx context.Context, namespace *corev1.Namespace) error {
+	logger := log.FromContext(ctx)
+
+	wanted := map[string]bool{}
+	for _, name := range r.ImagePullSecrets {
+		wanted[name] = true
+		if err := r.syncPullSecret(ctx, namespace, name); err != nil {
+			return err
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := r.APIReader.List(ctx, secrets, client.InNamespace(namespace.Name), client.MatchingLabels{MANAGED_BY_LABEL: MANAGED_BY}); err != nil {
+		return fmt.Errorf("listing secrets at namespace '%s' from the api: %w", namespace.Name, err)
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		source, isPullSecret := secret.Annotations[qtapv1.PULL_SECRET_SOURCE_ANNOTATION]
+		if !isPullSecret || wanted[source] {
+			continue
+		}
+
+		logger.Info("Deleting image pull secret", "namespace", namespace.Name, "secret", secret.Name)
+		if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting image pull secret: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// syncPullSecret copies the image pull secret from the operator namespace into the namespace
+func (r *NamespaceReconciler) syncPullSecret(ctx context.Context, namespace *corev1.Namespace, pullSecret string) error {
+	logger := log.FromContext(ctx)
+
+	source := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.OperatorNamespace, Name: pullSecret}, source); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("Image pull secret not found, not syncing", "namespace", r.OperatorNamespace, "secret", pullSecret)
+			return nil
+		}
+		return fmt.Errorf("fetching secret '%s' at namespace '%s': %w", pullSecret, r.OperatorNamespace, err)
+	}
+
+	return r.syncManagedSecret(ctx, namespace.Name, qtapv1.SyncedPullSecret(pullSecret), qtapv1.PULL_SECRET_SOURCE_ANNOTATION, pullSecret, source.Type, source.Data)
+}
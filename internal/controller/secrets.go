@@ -0,0 +1,95 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// errSecretReplaced is returned once a copy of a different type was deleted, the copy is recreated when the
+// namespace is reconciled again
+var errSecretReplaced = errors.New("managed secret replaced")
+
+// syncManagedSecret creates or updates the copy of a secret of the operator namespace in the namespace. The copy
+// records the name of its source in the annotation, secrets the operator didn't create (or copied from another
+// kind of secret) are never overwritten.
+// Secrets outside the operator namespace are not cached, so the copy is read from the api.
+func (r *NamespaceReconciler) syncManagedSecret(ctx context.Context, namespace string, name string, sourceAnnotation string, source string, secretType corev1.SecretType, data map[string][]byte) error {
+	logger := log.FromContext(ctx)
+
+	secret := &corev1.Secret{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret)
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					MANAGED_BY_LABEL: MANAGED_BY,
+				},
+				Annotations: map[string]string{
+					sourceAnnotation: source,
+				},
+			},
+			Type: secretType,
+			Data: data,
+		}
+
+		logger.Info("Creating secret", "namespace", namespace, "secret", name, "source", source)
+		if err := r.Create(ctx, secret); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("creating secret '%s' at namespace '%s': %w", name, namespace, err)
+		}
+	case err != nil:
+		return fmt.Errorf("fetching secret '%s' at namespace '%s': %w", name, namespace, err)
+	case secret.Labels[MANAGED_BY_LABEL] != MANAGED_BY:
+		// never overwrite a secret the operator didn't create
+		logger.Info("Secret exists but is not managed by the operator, not syncing", "namespace", namespace, "secret", name)
+	case secret.Annotations[sourceAnnotation] == "":
+		// nor a copy of another kind of secret, which would replace each other forever
+		logger.Info("Secret is a copy of another kind of secret, not syncing", "namespace", namespace, "secret", name, "annotation", sourceAnnotation)
+	case secret.Type != secretType:
+		// the type of a secret is immutable
+		logger.Info("Replacing secret", "namespace", namespace, "secret", name, "source", source)
+		if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("deleting secret '%s' at namespace '%s': %w", name, namespace, err)
+		}
+		return errSecretReplaced
+	case !reflect.DeepEqual(secret.Data, data) || secret.Annotations[sourceAnnotation] != source:
+		secret.Data = data
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[sourceAnnotation] = source
+
+		logger.Info("Updating secret", "namespace", namespace, "secret", name, "source", source)
+		if err := r.Update(ctx, secret); err != nil {
+			return fmt.Errorf("updating secret '%s' at namespace '%s': %w", name, namespace, err)
+		}
+	}
+
+	return nil
+}
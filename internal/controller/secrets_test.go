@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+func TestSyncedSecretNames(t *testing.T) {
+	tests := []struct {
+		token      string
+		pullSecret string
+	}{
+		{token: "pull-registry", pullSecret: "registry"},
+		{token: "token", pullSecret: "token"},
+		{token: "pull-token", pullSecret: "token"},
+		{token: "registry", pullSecret: "registry"},
+	}
+
+	for _, tt := range tests {
+		if token, pullSecret := qtapv1.SyncedTokenSecret(tt.token), qtapv1.SyncedPullSecret(tt.pullSecret); token == pullSecret {
+			t.Errorf("token %s and image pull secret %s are both synced as %s", tt.token, tt.pullSecret, token)
+		}
+	}
+
+	if name := qtapv1.SyncedTokenSecret(qtapv1.TOKEN_SECRET); name != "qtap-token" {
+		t.Errorf("the default token is synced as %s, want qtap-token", name)
+	}
+}
+
+func TestSyncedSecretCollision(t *testing.T) {
+	const namespace = "apps"
+	const operatorNamespace = "qpoint"
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := qtapv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	// a selectable token and an image pull secret the old prefixes synced under the same name
+	token := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pull-registry",
+			Namespace: operatorNamespace,
+			Labels:    map[string]string{qtapv1.TOKEN_SELECTABLE_LABEL: "true"},
+		},
+		Data: map[string][]byte{qtapv1.TOKEN_KEY: []byte("token")},
+	}
+	pullSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: operatorNamespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte("{}")},
+	}
+	instrumented := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace,
+			Labels:      map[string]string{qtapv1.NAMESPACE_EGRESS_LABEL: "inject"},
+			Annotations: map[string]string{qtapv1.TOKEN_SECRET_ANNOTATION: token.Name},
+		},
+	}
+
+	deletes := 0
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(token, pullSecret, instrumented).WithInterceptorFuncs(interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			deletes++
+			return c.Delete(ctx, obj, opts...)
+		},
+	}).Build()
+
+	overrides, err := qtapv1.NewOverridePolicy(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &NamespaceReconciler{
+		Client:            c,
+		Scheme:            scheme,
+		APIReader:         c,
+		OperatorNamespace: operatorNamespace,
+		ImagePullSecrets:  []string{pullSecret.Name},
+		Overrides:         overrides,
+	}
+
+	// the copies used to replace each other on every reconcile
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := r.reconcileTokens(ctx, instrumented, true); err != nil {
+			t.Fatalf("reconciling tokens: %v", err)
+		}
+		if err := r.reconcilePullSecrets(ctx, instrumented); err != nil {
+			t.Fatalf("reconciling image pull secrets: %v", err)
+		}
+	}
+	if deletes > 0 {
+		t.Errorf("%d copies were deleted", deletes)
+	}
+
+	tokenCopy := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: qtapv1.SyncedTokenSecret(token.Name)}, tokenCopy); err != nil {
+		t.Fatalf("fetching the token copy: %v", err)
+	}
+	if tokenCopy.Annotations[qtapv1.TOKEN_SOURCE_ANNOTATION] != token.Name || tokenCopy.Type != corev1.SecretTypeOpaque {
+		t.Errorf("token copy %s has source %q and type %s", tokenCopy.Name, tokenCopy.Annotations[qtapv1.TOKEN_SOURCE_ANNOTATION], tokenCopy.Type)
+	}
+
+	pullSecretCopy := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: qtapv1.SyncedPullSecret(pullSecret.Name)}, pullSecretCopy); err != nil {
+		t.Fatalf("fetching the image pull secret copy: %v", err)
+	}
+	if pullSecretCopy.Annotations[qtapv1.PULL_SECRET_SOURCE_ANNOTATION] != pullSecret.Name || pullSecretCopy.Type != corev1.SecretTypeDockerConfigJson {
+		t.Errorf("image pull secret copy %s has source %q and type %s", pullSecretCopy.Name, pullSecretCopy.Annotations[qtapv1.PULL_SECRET_SOURCE_ANNOTATION], pullSecretCopy.Type)
+	}
+}
+
+func TestSyncManagedSecretOwnership(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy of a token named like the copy of an image pull secret, as the previous prefix synced it
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "qtap-pull-registry",
+			Namespace:   "apps",
+			Labels:      map[string]string{MANAGED_BY_LABEL: MANAGED_BY},
+			Annotations: map[string]string{qtapv1.TOKEN_SOURCE_ANNOTATION: "pull-registry"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{qtapv1.TOKEN_KEY: []byte("token")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+	r := &NamespaceReconciler{Client: c, Scheme: scheme, APIReader: c}
+
+	ctx := context.Background()
+	err := r.syncManagedSecret(ctx, "apps", "qtap-pull-registry", qtapv1.PULL_SECRET_SOURCE_ANNOTATION, "registry", corev1.SecretTypeDockerConfigJson, map[string][]byte{corev1.DockerConfigJsonKey: []byte("{}")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(existing), secret); err != nil {
+		t.Fatalf("the copy of the token is gone: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeOpaque || secret.Annotations[qtapv1.TOKEN_SOURCE_ANNOTATION] != "pull-registry" {
+		t.Errorf("the copy of the token was overwritten: %v", secret)
+	}
+}
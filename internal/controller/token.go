@@ -0,0 +1,167 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	qtapv1 "github.com/qpoint-io/kubernetes-qtap-operator/api/v1"
+)
+
+// reconcileTokens syncs the token secrets used in the namespace from the operator namespace as qtap-token-<name>,
+// which the qtap sidecars reference with a secretKeyRef. The copies are updated whenever a source secret
+// changes and removed once nothing in the namespace uses them. The token secrets that exist are returned.
+func (r *NamespaceReconciler) reconcileTokens(ctx context.Context, namespace *corev1.Namespace, instrumented bool) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	wanted, err := r.tokenSecrets(ctx, namespace, instrumented)
+	if err != nil {
+		return nil, err
+	}
+
+	synced := []string{}
+	for _, tokenSecret := range sortedKeys(wanted) {
+		exists, err := r.syncToken(ctx, namespace, tokenSecret)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			synced = append(synced, tokenSecret)
+			continue
+		}
+		// the copy of a secret that is gone or no longer selectable is removed below
+		delete(wanted, tokenSecret)
+	}
+
+	// remove the copies that are no longer used
+	tokens := &corev1.SecretList{}
+	if err := r.APIReader.List(ctx, tokens, client.InNamespace(namespace.Name), client.MatchingLabels{MANAGED_BY_LABEL: MANAGED_BY}); err != nil {
+		return nil, fmt.Errorf("listing secrets at namespace '%s' from the api: %w", namespace.Name, err)
+	}
+	for i := range tokens.Items {
+		token := &tokens.Items[i]
+		source, isToken := token.Annotations[qtapv1.TOKEN_SOURCE_ANNOTATION]
+		if !isToken || wanted[source] {
+			continue
+		}
+
+		logger.Info("Deleting token secret", "namespace", namespace.Name, "secret", token.Name)
+		if err := r.Delete(ctx, token); client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("deleting secret for Qtap token: %w", err)
+		}
+	}
+
+	return synced, nil
+}
+
+// tokenSecrets determines the token secrets used in the namespace. Sidecars that were already injected keep
+// using their token, while an instrumented namespace also needs the token of the namespace and its policies
+// for pods that are yet to be created.
+func (r *NamespaceReconciler) tokenSecrets(ctx context.Context, namespace *corev1.Namespace, instrumented bool) (map[string]bool, error) {
+	wanted := map[string]bool{}
+
+	pods := &metav1.PartialObjectMetadataList{}
+	pods.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+	if err := r.List(ctx, pods, client.InNamespace(namespace.Name)); err != nil {
+		return nil, fmt.Errorf("listing pods at namespace '%s' from the api: %w", namespace.Name, err)
+	}
+	for _, pod := range pods.Items {
+		if qtapv1.HasSidecar(pod.Annotations) || (instrumented && pod.Annotations[qtapv1.TOKEN_SECRET_ANNOTATION] != "") {
+			wanted[qtapv1.TokenSecretOf(pod.Annotations)] = true
+		}
+	}
+
+	if !instrumented {
+		return wanted, nil
+	}
+
+	// a namespace that may not override the token secret uses the default, pods in it are rejected by the webhook
+	if r.Overrides.Blocked(qtapv1.TOKEN_SECRET_ANNOTATION) {
+		wanted[qtapv1.TOKEN_SECRET] = true
+	} else {
+		wanted[qtapv1.TokenSecretOf(namespace.Annotations)] = true
+	}
+
+	policies := &qtapv1.QtapEgressPolicyList{}
+	if err := r.List(ctx, policies, client.InNamespace(namespace.Name)); err != nil && !meta.IsNoMatchError(err) {
+		return nil, fmt.Errorf("listing egress policies at namespace '%s' from the api: %w", namespace.Name, err)
+	}
+	for _, policy := range policies.Items {
+		if policy.Spec.TokenSecret != "" {
+			wanted[policy.Spec.TokenSecret] = true
+		}
+	}
+
+	clusterPolicies := &qtapv1.ClusterQtapEgressPolicyList{}
+	if err := r.List(ctx, clusterPolicies); err != nil && !meta.IsNoMatchError(err) {
+		return nil, fmt.Errorf("listing cluster egress policies from the api: %w", err)
+	}
+	for _, policy := range clusterPolicies.Items {
+		if policy.Spec.TokenSecret != "" {
+			wanted[policy.Spec.TokenSecret] = true
+		}
+	}
+
+	return wanted, nil
+}
+
+// syncToken copies the token secret from the operator namespace into the namespace. Whether the source secret
+// exists and is selectable is returned.
+func (r *NamespaceReconciler) syncToken(ctx context.Context, namespace *corev1.Namespace, tokenSecret string) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	source := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.OperatorNamespace, Name: tokenSecret}, source); err != nil {
+		if apierrors.IsNotFound(err) {
+			// the sidecars will wait for the secret
+			logger.Info("Token secret not found, not syncing", "namespace", r.OperatorNamespace, "secret", tokenSecret)
+			return false, nil
+		}
+		return false, fmt.Errorf("fetching secret '%s' at namespace '%s': %w", tokenSecret, r.OperatorNamespace, err)
+	}
+
+	// only the secrets an administrator made selectable are copied out of the operator namespace
+	if !qtapv1.IsSelectableToken(source) {
+		logger.Info("Token secret is not selectable, not syncing", "namespace", r.OperatorNamespace, "secret", tokenSecret, "label", qtapv1.TOKEN_SELECTABLE_LABEL)
+		return false, nil
+	}
+
+	data := map[string][]byte{qtapv1.TOKEN_KEY: source.Data[qtapv1.TOKEN_KEY]}
+	if err := r.syncManagedSecret(ctx, namespace.Name, qtapv1.SyncedTokenSecret(tokenSecret), qtapv1.TOKEN_SOURCE_ANNOTATION, tokenSecret, corev1.SecretTypeOpaque, data); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}